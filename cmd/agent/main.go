@@ -14,6 +14,9 @@ func main() {
 
 	// Инициализация клиента
 	client := agent.NewClient(orchURL)
+	if id := getEnv("AGENT_ID", ""); id != "" {
+		client.SetAgentID(id)
+	}
 
 	// Создание и запуск агента
 	a := agent.NewAgent(client, workersNum)
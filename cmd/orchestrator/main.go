@@ -4,19 +4,65 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"calc_service/internal/orchestrator/api"
+	"calc_service/internal/orchestrator/events"
+	"calc_service/internal/orchestrator/rates"
 	"calc_service/internal/orchestrator/storage"
+	"calc_service/internal/orchestrator/webhook"
 )
 
 func main() {
 	//Инициализация хранилища
 	store := storage.NewMemoryStorage()
+	if ms, err := strconv.Atoi(os.Getenv("TASK_TIMEOUT_MS")); err == nil && ms > 0 {
+		store.SetTaskTimeout(time.Duration(ms) * time.Millisecond)
+	}
+	if ms, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_WINDOW_MS")); err == nil && ms > 0 {
+		store.SetIdempotencyWindow(time.Duration(ms) * time.Millisecond)
+	}
+	if ms, err := strconv.Atoi(os.Getenv("AGING_INTERVAL_MS")); err == nil && ms > 0 {
+		store.SetAgingInterval(time.Duration(ms) * time.Millisecond)
+	}
+	// TENANT_WEIGHTS=web=4,batch=1 — доли агентов, которые получают клиенты
+	for _, pair := range strings.Split(os.Getenv("TENANT_WEIGHTS"), ",") {
+		tenant, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		weight, err := strconv.Atoi(raw)
+		if err != nil || weight < 1 {
+			log.Fatalf("Некорректный вес клиента %q в TENANT_WEIGHTS", tenant)
+		}
+		store.SetTenantWeight(tenant, weight)
+	}
+	store.OnExpressionFinished(webhook.NewDispatcher(store).Notify)
+	hub := events.NewHub()
+	hub.Attach(store)
 	handler := api.NewHandler(store)
+	handler.SetEventHub(hub)
+	if path := os.Getenv("RATES_FILE"); path != "" {
+		provider, err := rates.NewFileProvider(path)
+		if err != nil {
+			log.Fatalf("Ошибка загрузки курсов валют: %v", err)
+		}
+		handler.SetRateProvider(provider)
+	}
 
 	http.HandleFunc("/api/v1/calculate", handler.CalculateHandler)
+	http.HandleFunc("/api/v1/calculate/batch", handler.BatchHandler)
+	http.HandleFunc("/api/v1/batches/", handler.GetBatchHandler)
 	http.HandleFunc("/api/v1/expressions", handler.GetExpressionsHandler)
-	http.HandleFunc("/api/v1/expressions/", handler.GetExpressionHandler)
+	http.HandleFunc("/api/v1/expressions/", handler.ExpressionHandler)
+	http.HandleFunc("/api/v1/events", handler.EventsHandler)
+	http.HandleFunc("/api/v1/ws", handler.WebSocketHandler)
+	http.HandleFunc("/api/v1/explain", handler.ExplainHandler)
+	http.HandleFunc("/api/v1/functions", handler.FunctionsHandler)
+	http.HandleFunc("/api/v1/derivative", handler.DerivativeHandler)
+	http.HandleFunc("/api/v1/format", handler.FormatHandler)
 	http.HandleFunc("/internal/task", handler.TaskHandler)
 
 	port := os.Getenv("PORT")
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"time"
 
 	"calc_service/pkg/errors"
@@ -18,24 +19,49 @@ const (
 	retryDelay     = 1 * time.Second
 )
 
+// AgentIDHeader — заголовок, которым агент представляется оркестратору
+const AgentIDHeader = "X-Agent-ID"
+
 type OrchestratorClient struct {
 	baseURL    string
+	agentID    string
 	httpClient *http.Client
 }
 
 func NewClient(baseURL string) *OrchestratorClient {
 	return &OrchestratorClient{
 		baseURL: baseURL,
+		agentID: defaultAgentID(),
 		httpClient: &http.Client{
 			Timeout: defaultTimeout,
 		},
 	}
 }
 
+// SetAgentID задаёт имя, под которым агент виден в графах и трассировках выражений
+func (c *OrchestratorClient) SetAgentID(id string) {
+	c.agentID = id
+}
+
+// defaultAgentID составляет имя агента из имени хоста и PID процесса
+func defaultAgentID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "agent"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 // Получение задачи от оркестратора
 func (c *OrchestratorClient) FetchTask() (*models.Task, error) {
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		resp, err := c.httpClient.Get(c.baseURL + "/internal/task")
+		req, err := http.NewRequest(http.MethodGet, c.baseURL+"/internal/task", nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(AgentIDHeader, c.agentID)
+
+		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			time.Sleep(retryDelay)
 			continue
@@ -60,25 +86,34 @@ func (c *OrchestratorClient) FetchTask() (*models.Task, error) {
 
 // Отправка результата выполнения задачи
 func (c *OrchestratorClient) SubmitResult(taskID string, result float64) error {
-	payload := struct {
-		TaskID string  `json:"task_id"`
-		Result float64 `json:"result"`
-	}{
-		TaskID: taskID,
-		Result: result,
-	}
+	return c.submit(models.TaskResult{TaskID: taskID, Result: result})
+}
 
+// Отправка результата задачи, который является массивом
+func (c *OrchestratorClient) SubmitArrayResult(taskID string, results []float64) error {
+	return c.submit(models.TaskResult{TaskID: taskID, Results: results})
+}
+
+// Сообщение о том, что задачу выполнить не удалось
+func (c *OrchestratorClient) SubmitError(taskID string, taskErr error) error {
+	return c.submit(models.TaskResult{TaskID: taskID, Error: taskErr.Error()})
+}
+
+func (c *OrchestratorClient) submit(payload models.TaskResult) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("ошибка кодирования результата: %w", err)
 	}
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		resp, err := c.httpClient.Post(
-			c.baseURL+"/internal/task",
-			"application/json",
-			bytes.NewReader(body),
-		)
+		req, err := http.NewRequest(http.MethodPost, c.baseURL+"/internal/task", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(AgentIDHeader, c.agentID)
+
+		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			time.Sleep(retryDelay)
 			continue
@@ -0,0 +1,15 @@
+package agent
+
+import (
+	"math"
+	"time"
+)
+
+// weekday возвращает день недели момента времени по ISO 8601: 1 — понедельник, 7 — воскресенье
+func weekday(seconds float64) float64 {
+	day := time.Unix(int64(math.Floor(seconds)), 0).UTC().Weekday()
+	if day == time.Sunday {
+		return 7
+	}
+	return float64(day)
+}
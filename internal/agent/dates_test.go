@@ -0,0 +1,20 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeekday(t *testing.T) {
+	date := func(s string) float64 {
+		d, err := time.Parse("2006-01-02T15:04", s)
+		assert.NoError(t, err)
+		return float64(d.Unix())
+	}
+
+	assert.Equal(t, 1.0, weekday(date("2026-10-19T00:00"))) // Понедельник
+	assert.Equal(t, 7.0, weekday(date("2026-10-18T23:59"))) // Воскресенье
+	assert.Equal(t, 4.0, weekday(0))                        // 1970-01-01 — четверг
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"math/big"
+	"strconv"
+
+	"calc_service/pkg/errors"
+)
+
+// Знаков после запятой в частном, которое не выражается конечной десятичной дробью
+const decimalPrecision = 34
+
+func isArithmetic(op string) bool {
+	return op == "+" || op == "-" || op == "*" || op == "/"
+}
+
+// decimal выполняет операцию над десятичными дробями, которые записывают операнды:
+// 0.1 + 0.2 даёт ровно 0.3, а не 0.30000000000000004. Результат округляется
+// до ближайшего float64, поэтому его кратчайшая запись снова точная десятичная дробь
+func decimal(op string, a, b float64) (float64, error) {
+	x, y := exactDecimal(a), exactDecimal(b)
+	res := new(big.Rat)
+	switch op {
+	case "+":
+		res.Add(x, y)
+	case "-":
+		res.Sub(x, y)
+	case "*":
+		res.Mul(x, y)
+	case "/":
+		if y.Sign() == 0 {
+			return 0, errors.ErrDivisionByZero
+		}
+		res.Quo(x, y)
+		// Бесконечную дробь округляем в десятичной записи, а не в двоичной
+		res.SetString(res.FloatString(decimalPrecision))
+	default:
+		return 0, errors.ErrInvalidOperation
+	}
+	f, _ := res.Float64()
+	return f, nil
+}
+
+// exactDecimal восстанавливает десятичную дробь по кратчайшей записи числа
+func exactDecimal(v float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(v, 'g', -1, 64))
+	if !ok {
+		return new(big.Rat).SetFloat64(v)
+	}
+	return r
+}
@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"testing"
+
+	"calc_service/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecimalOperations(t *testing.T) {
+	t.Run("Без накопления ошибки", func(t *testing.T) {
+		result, err := decimal("+", 0.1, 0.2)
+		assert.NoError(t, err)
+		assert.Equal(t, 0.3, result)
+
+		result, err = decimal("*", 1.1, 1.1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1.21, result)
+
+		result, err = decimal("-", 100.07, 0.07)
+		assert.NoError(t, err)
+		assert.Equal(t, 100.0, result)
+	})
+
+	t.Run("Деление", func(t *testing.T) {
+		result, err := decimal("/", 1, 3)
+		assert.NoError(t, err)
+		assert.InDelta(t, 1.0/3, result, 1e-15)
+
+		_, err = decimal("/", 1, 0)
+		assert.ErrorIs(t, err, errors.ErrDivisionByZero)
+	})
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"math"
+
+	"calc_service/pkg/errors"
+)
+
+// Интервальная арифметика с направленным округлением. Go не позволяет переключить
+// режим округления, поэтому ошибка каждой операции вычисляется точно (TwoSum, FMA),
+// и граница сдвигается на шаг наружу, только если результат действительно округлён.
+
+// interval выполняет операцию над интервалами; args — границы операндов подряд
+func interval(op string, args []float64) ([]float64, error) {
+	arity := 2
+	if op == "sqrt" || op == "abs" {
+		arity = 1
+	}
+	if len(args) != 2*arity {
+		return nil, errors.ErrInvalidOperation
+	}
+	a1, a2 := args[0], args[1]
+	if a1 > a2 {
+		return nil, errors.ErrInvalidOperation
+	}
+
+	switch op {
+	case "sqrt":
+		if a2 < 0 {
+			return nil, errors.ErrNegativeRoot
+		}
+		// Корень определён только на неотрицательной части интервала
+		lo, _ := sqrtBounds(math.Max(a1, 0))
+		_, hi := sqrtBounds(a2)
+		return []float64{lo, hi}, nil
+	case "abs":
+		switch {
+		case a1 >= 0:
+			return []float64{a1, a2}, nil
+		case a2 <= 0:
+			return []float64{-a2, -a1}, nil
+		}
+		return []float64{0, math.Max(-a1, a2)}, nil
+	}
+
+	b1, b2 := args[2], args[3]
+	if b1 > b2 {
+		return nil, errors.ErrInvalidOperation
+	}
+	switch op {
+	case "+":
+		lo, _ := sumBounds(a1, b1)
+		_, hi := sumBounds(a2, b2)
+		return []float64{lo, hi}, nil
+	case "-":
+		lo, _ := sumBounds(a1, -b2)
+		_, hi := sumBounds(a2, -b1)
+		return []float64{lo, hi}, nil
+	case "*":
+		return hull(productBounds, a1, a2, b1, b2), nil
+	case "/":
+		if b1 <= 0 && b2 >= 0 {
+			return nil, errors.ErrDivisionByZero
+		}
+		return hull(quotientBounds, a1, a2, b1, b2), nil
+	}
+	return nil, errors.ErrInvalidOperation
+}
+
+// hull возвращает наименьший интервал, содержащий результаты операции над всеми
+// сочетаниями границ — для умножения и деления этого достаточно
+func hull(bounds func(x, y float64) (float64, float64), a1, a2, b1, b2 float64) []float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, x := range []float64{a1, a2} {
+		for _, y := range []float64{b1, b2} {
+			l, h := bounds(x, y)
+			lo, hi = math.Min(lo, l), math.Max(hi, h)
+		}
+	}
+	return []float64{lo, hi}
+}
+
+// outward превращает округлённый результат r и знак его ошибки в границы точного значения
+func outward(r, err float64) (float64, float64) {
+	switch {
+	case err > 0:
+		return r, math.Nextafter(r, math.Inf(1))
+	case err < 0:
+		return math.Nextafter(r, math.Inf(-1)), r
+	}
+	return r, r
+}
+
+// sumBounds — границы точной суммы x + y (ошибка округления по TwoSum)
+func sumBounds(x, y float64) (float64, float64) {
+	s := x + y
+	if math.IsInf(s, 0) {
+		return s, s
+	}
+	yy := s - x
+	err := (x - (s - yy)) + (y - yy)
+	return outward(s, err)
+}
+
+// productBounds — границы точного произведения x * y (ошибка через FMA)
+func productBounds(x, y float64) (float64, float64) {
+	p := x * y
+	switch {
+	case math.IsInf(p, 0) || x == 0 || y == 0:
+		return p, p
+	case p == 0:
+		// Произведение ушло в ноль, но точное значение не ноль: оно меньше наименьшего
+		// числа по модулю и того же знака, что и произведение
+		if (x > 0) == (y > 0) {
+			return 0, math.SmallestNonzeroFloat64
+		}
+		return -math.SmallestNonzeroFloat64, 0
+	case math.Abs(p) < minNormal:
+		// Ошибка округления денормализованного произведения сама может уйти в ноль
+		return math.Nextafter(p, math.Inf(-1)), math.Nextafter(p, math.Inf(1))
+	}
+	return outward(p, math.FMA(x, y, -p))
+}
+
+// minNormal — наименьшее положительное нормализованное число float64
+const minNormal = 0x1p-1022
+
+// quotientBounds — границы точного частного x / y: остаток x - q*y вычисляется
+// точно, и точное частное больше q, если остаток одного знака с делителем
+func quotientBounds(x, y float64) (float64, float64) {
+	q := x / y
+	if math.IsInf(q, 0) || q == 0 && x == 0 {
+		return q, q
+	}
+	r := math.FMA(-q, y, x)
+	if y < 0 {
+		r = -r
+	}
+	return outward(q, r)
+}
+
+// sqrtBounds — границы точного корня: если s*s больше x, точный корень меньше s
+func sqrtBounds(x float64) (float64, float64) {
+	s := math.Sqrt(x)
+	if math.IsInf(s, 0) || s == 0 {
+		return s, s
+	}
+	return outward(s, -math.FMA(s, s, -x))
+}
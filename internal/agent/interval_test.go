@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"math"
+	"testing"
+
+	"calc_service/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntervalOperations(t *testing.T) {
+	t.Run("Точные операции не расширяют границы", func(t *testing.T) {
+		result, err := interval("+", []float64{1, 2, 3, 4})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{4, 6}, result)
+
+		result, err = interval("-", []float64{1, 2, 3, 4})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{-3, -1}, result)
+
+		result, err = interval("*", []float64{-1, 2, 3, 4})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{-4, 8}, result)
+
+		result, err = interval("sqrt", []float64{4, 9})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{2, 3}, result)
+
+		result, err = interval("abs", []float64{-3, 2})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{0, 3}, result)
+	})
+
+	t.Run("Округлённый результат окружается границами", func(t *testing.T) {
+		// 0.1 + 0.2 не представимо точно: границы отличаются на шаг
+		result, err := interval("+", []float64{0.1, 0.1, 0.2, 0.2})
+		assert.NoError(t, err)
+		assert.Equal(t, math.Nextafter(result[0], math.Inf(1)), result[1])
+
+		result, err = interval("/", []float64{1, 1, 3, 3})
+		assert.NoError(t, err)
+		assert.Less(t, result[0], result[1])
+		assert.LessOrEqual(t, result[0], 1.0/3)
+		assert.GreaterOrEqual(t, result[1], 1.0/3)
+
+		result, err = interval("sqrt", []float64{2, 2})
+		assert.NoError(t, err)
+		assert.Less(t, result[0]*result[0], 2.0)
+		assert.Greater(t, result[1]*result[1], 2.0)
+	})
+
+	t.Run("Произведение, ушедшее в ноль", func(t *testing.T) {
+		result, err := interval("*", []float64{1e-200, 1e-200, 1e-200, 1e-200})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{0, math.SmallestNonzeroFloat64}, result)
+
+		result, err = interval("*", []float64{-1e-200, -1e-200, 1e-200, 1e-200})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{-math.SmallestNonzeroFloat64, 0}, result)
+
+		// Денормализованное произведение расширяется на шаг в обе стороны
+		result, err = interval("*", []float64{3e-160, 3e-160, 1e-160, 1e-160})
+		assert.NoError(t, err)
+		assert.Less(t, result[0], 3e-160*1e-160)
+		assert.Greater(t, result[1], 3e-160*1e-160)
+		assert.Greater(t, result[0], 0.0)
+
+		result, err = interval("*", []float64{0, 0, 1e-200, 1e-200})
+		assert.NoError(t, err)
+		assert.Equal(t, []float64{0, 0}, result)
+	})
+
+	t.Run("Ошибки", func(t *testing.T) {
+		_, err := interval("/", []float64{1, 2, -1, 1})
+		assert.ErrorIs(t, err, errors.ErrDivisionByZero)
+
+		_, err = interval("sqrt", []float64{-2, -1})
+		assert.ErrorIs(t, err, errors.ErrNegativeRoot)
+
+		_, err = interval("+", []float64{1, 2})
+		assert.ErrorIs(t, err, errors.ErrInvalidOperation)
+	})
+}
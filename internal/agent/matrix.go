@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"math"
+
+	"calc_service/pkg/errors"
+)
+
+// Матрицы передаются по строкам в одном срезе
+
+// machineEpsilon — расстояние от 1 до следующего числа float64
+const machineEpsilon = 0x1p-52
+
+// matmul умножает матрицу a (m×n) на матрицу b (n×p)
+func matmul(a, b []float64, m, n, p int) []float64 {
+	result := make([]float64, m*p)
+	for i := 0; i < m; i++ {
+		for k := 0; k < n; k++ {
+			aik := a[i*n+k]
+			for j := 0; j < p; j++ {
+				result[i*p+j] += aik * b[k*p+j]
+			}
+		}
+	}
+	return result
+}
+
+// determinant вычисляет определитель матрицы n×n методом Гаусса с выбором ведущего элемента
+func determinant(values []float64, n int) float64 {
+	m := append([]float64(nil), values...)
+	tol := tolerance(m, n)
+	det := 1.0
+
+	for col := 0; col < n; col++ {
+		pivot := pivotRow(m, n, col)
+		if math.Abs(m[pivot*n+col]) <= tol {
+			return 0
+		}
+		if pivot != col {
+			swapRows(m, n, pivot, col)
+			det = -det
+		}
+
+		det *= m[col*n+col]
+		for row := col + 1; row < n; row++ {
+			factor := m[row*n+col] / m[col*n+col]
+			for k := col; k < n; k++ {
+				m[row*n+k] -= factor * m[col*n+k]
+			}
+		}
+	}
+	return det
+}
+
+// inverse обращает матрицу n×n методом Гаусса — Жордана
+func inverse(values []float64, n int) ([]float64, error) {
+	m := append([]float64(nil), values...)
+	tol := tolerance(m, n)
+	inv := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		inv[i*n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := pivotRow(m, n, col)
+		if math.Abs(m[pivot*n+col]) <= tol {
+			return nil, errors.ErrSingularMatrix
+		}
+		swapRows(m, n, pivot, col)
+		swapRows(inv, n, pivot, col)
+
+		scale := m[col*n+col]
+		for k := 0; k < n; k++ {
+			m[col*n+k] /= scale
+			inv[col*n+k] /= scale
+		}
+
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+			factor := m[row*n+col]
+			for k := 0; k < n; k++ {
+				m[row*n+k] -= factor * m[col*n+k]
+				inv[row*n+k] -= factor * inv[col*n+k]
+			}
+		}
+	}
+	return inv, nil
+}
+
+// tolerance — порог, ниже которого ведущий элемент считается нулевым. Порог пропорционален
+// наибольшему по модулю элементу матрицы: погрешность исключения растёт вместе с элементами,
+// поэтому малая, но невырожденная матрица не считается вырожденной, а у большой матрицы
+// остаток округления не принимается за ведущий элемент
+func tolerance(m []float64, n int) float64 {
+	norm := 0.0
+	for _, v := range m {
+		norm = math.Max(norm, math.Abs(v))
+	}
+	return float64(n) * machineEpsilon * norm
+}
+
+// pivotRow ищет строку с наибольшим по модулю элементом в столбце col, начиная с диагонали
+func pivotRow(m []float64, n, col int) int {
+	pivot := col
+	for row := col + 1; row < n; row++ {
+		if math.Abs(m[row*n+col]) > math.Abs(m[pivot*n+col]) {
+			pivot = row
+		}
+	}
+	return pivot
+}
+
+func swapRows(m []float64, n, a, b int) {
+	if a == b {
+		return
+	}
+	for k := 0; k < n; k++ {
+		m[a*n+k], m[b*n+k] = m[b*n+k], m[a*n+k]
+	}
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"testing"
+
+	"calc_service/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatrixOperations(t *testing.T) {
+	t.Run("Умножение", func(t *testing.T) {
+		// [1 2 3] × [[1], [2], [3]]
+		result := matmul([]float64{1, 2, 3}, []float64{1, 2, 3}, 1, 3, 1)
+		assert.Equal(t, []float64{14}, result)
+	})
+
+	t.Run("Определитель с перестановкой строк", func(t *testing.T) {
+		assert.InDelta(t, -2.0, determinant([]float64{1, 2, 3, 4}, 2), 1e-9)
+		assert.InDelta(t, -1.0, determinant([]float64{0, 1, 0, 1, 0, 0, 0, 0, 1}, 3), 1e-9)
+		assert.Equal(t, 0.0, determinant([]float64{1, 2, 2, 4}, 2))
+	})
+
+	t.Run("Обращение", func(t *testing.T) {
+		inv, err := inverse([]float64{4, 7, 2, 6}, 2)
+		assert.NoError(t, err)
+		assert.InDeltaSlice(t, []float64{0.6, -0.7, -0.2, 0.4}, inv, 1e-9)
+
+		_, err = inverse([]float64{1, 2, 2, 4}, 2)
+		assert.ErrorIs(t, err, errors.ErrSingularMatrix)
+	})
+
+	t.Run("Порог вырожденности относительно нормы", func(t *testing.T) {
+		// Малая невырожденная матрица 1e-7·I
+		small := []float64{1e-7, 0, 0, 0, 1e-7, 0, 0, 0, 1e-7}
+		assert.InDelta(t, 1e-21, determinant(small, 3), 1e-30)
+		inv, err := inverse(small, 3)
+		assert.NoError(t, err)
+		assert.InDeltaSlice(t, []float64{1e7, 0, 0, 0, 1e7, 0, 0, 0, 1e7}, inv, 1e-3)
+
+		// Вырожденная матрица с большими элементами: после исключения остаётся только
+		// погрешность округления, которая больше абсолютного порога
+		large := []float64{1e6, 2e6, 3e6, 4e6, 5e6, 6e6, 7e6, 8e6, 9e6}
+		assert.Equal(t, 0.0, determinant(large, 3))
+		_, err = inverse(large, 3)
+		assert.ErrorIs(t, err, errors.ErrSingularMatrix)
+
+		_, err = inverse(make([]float64, 4), 2)
+		assert.ErrorIs(t, err, errors.ErrSingularMatrix)
+	})
+}
@@ -2,6 +2,8 @@ package agent
 
 import (
 	"log"
+	"math"
+	"sort"
 	"time"
 
 	"calc_service/pkg/errors"
@@ -29,9 +31,21 @@ func (w *Worker) Start() {
 			continue
 		}
 
+		if task.ReturnsArray() {
+			results, err := w.executeArrayTask(task)
+			if err != nil {
+				w.reportError(task, err)
+				continue
+			}
+			if err := w.client.SubmitArrayResult(task.ID, results); err != nil {
+				log.Printf("Ошибка отправки результата для задачи %s: %v", task.ID, err)
+			}
+			continue
+		}
+
 		result, err := w.executeTask(task)
 		if err != nil {
-			log.Printf("Ошибка выполнения задачи %s: %v", task.ID, err)
+			w.reportError(task, err)
 			continue
 		}
 
@@ -41,10 +55,23 @@ func (w *Worker) Start() {
 	}
 }
 
+// reportError сообщает оркестратору, что задачу выполнить нельзя, чтобы выражение
+// завершилось с ошибкой, а не ждало результата
+func (w *Worker) reportError(task *models.Task, err error) {
+	log.Printf("Ошибка выполнения задачи %s: %v", task.ID, err)
+	if err := w.client.SubmitError(task.ID, err); err != nil {
+		log.Printf("Ошибка отправки ошибки для задачи %s: %v", task.ID, err)
+	}
+}
+
 func (w *Worker) executeTask(task *models.Task) (float64, error) {
 	// Имитация долгого выполнения операции
 	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
 
+	if task.Decimal && isArithmetic(task.Operation) {
+		return decimal(task.Operation, task.Arg1, task.Arg2)
+	}
+
 	switch task.Operation {
 	case "+":
 		return task.Arg1 + task.Arg2, nil
@@ -57,7 +84,65 @@ func (w *Worker) executeTask(task *models.Task) (float64, error) {
 			return 0, errors.ErrDivisionByZero
 		}
 		return task.Arg1 / task.Arg2, nil
+	case "sqrt":
+		if task.Arg1 < 0 {
+			return 0, errors.ErrNegativeRoot
+		}
+		return math.Sqrt(task.Arg1), nil
+	case "abs":
+		return math.Abs(task.Arg1), nil
+	case "weekday":
+		return weekday(task.Arg1), nil
+	case "median":
+		return median(task.Args)
+	case "det":
+		if len(task.Shape) != 2 || task.Shape[0]*task.Shape[1] != len(task.Args) {
+			return 0, errors.ErrMatrixShape
+		}
+		return determinant(task.Args, task.Shape[0]), nil
 	default:
 		return 0, errors.ErrInvalidOperation
 	}
 }
+
+// executeArrayTask выполняет операцию, результат которой — массив по строкам
+func (w *Worker) executeArrayTask(task *models.Task) ([]float64, error) {
+	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+
+	if task.Interval {
+		return interval(task.Operation, task.Args)
+	}
+
+	switch task.Operation {
+	case "matmul":
+		if len(task.Shape) != 3 {
+			return nil, errors.ErrMatrixShape
+		}
+		m, n, p := task.Shape[0], task.Shape[1], task.Shape[2]
+		if m*n+n*p != len(task.Args) {
+			return nil, errors.ErrMatrixShape
+		}
+		return matmul(task.Args[:m*n], task.Args[m*n:], m, n, p), nil
+	case "inv":
+		if len(task.Shape) != 2 || task.Shape[0]*task.Shape[1] != len(task.Args) {
+			return nil, errors.ErrMatrixShape
+		}
+		return inverse(task.Args, task.Shape[0])
+	default:
+		return nil, errors.ErrInvalidOperation
+	}
+}
+
+func median(values []float64) (float64, error) {
+	if len(values) == 0 {
+		return 0, errors.ErrEmptyList
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2, nil
+	}
+	return sorted[mid], nil
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"calc_service/pkg/errors"
+	"calc_service/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// maxBatchSize — сколько выражений можно отправить одним пакетом
+const maxBatchSize = 10000
+
+// batchRequest — тело запроса на вычисление пакета выражений
+type batchRequest struct {
+	Expressions []batchItemRequest `json:"expressions"`
+}
+
+// batchItemRequest — выражение пакета с необязательным ключом клиента
+type batchItemRequest struct {
+	Key string `json:"key"`
+	calculateRequest
+}
+
+// batchStatus — ход вычисления пакета
+type batchStatus struct {
+	ID        string            `json:"id"`
+	Total     int               `json:"total"`    // Элементов в пакете
+	Rejected  int               `json:"rejected"` // Не принятых при отправке
+	Progress  map[string]int    `json:"progress"` // Принятые выражения по статусам
+	Finished  bool              `json:"finished"` // Все принятые выражения завершены
+	Items     []batchItemStatus `json:"items"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type batchItemStatus struct {
+	Key    string   `json:"key,omitempty"`
+	ID     string   `json:"id,omitempty"`
+	Status string   `json:"status"` // Статус выражения; rejected — не принято, deleted — удалено
+	Result *float64 `json:"result,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Обработчик пакетной отправки выражений: каждое проверяется отдельно, и ответ содержит
+// ID принятых выражений и ошибки отклонённых в порядке запроса
+func (h *Handler) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Некорректный JSON", http.StatusBadRequest)
+		return
+	}
+	if len(request.Expressions) == 0 {
+		http.Error(w, errors.ErrEmptyList.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(request.Expressions) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("в пакете больше %d выражений", maxBatchSize), http.StatusUnprocessableEntity)
+		return
+	}
+
+	batch := &models.Batch{
+		ID:        uuid.New().String(),
+		Items:     make([]models.BatchItem, len(request.Expressions)),
+		CreatedAt: time.Now(),
+	}
+	accepted := make([]*models.Expression, len(request.Expressions))
+	keys := make(map[string]bool)
+	for i := range request.Expressions {
+		item := &request.Expressions[i]
+		item.Tenant = r.Header.Get(TenantHeader)
+		batch.Items[i].Key = item.Key
+		if item.Key != "" {
+			if keys[item.Key] {
+				batch.Items[i].Error = fmt.Sprintf("ключ %q уже встречался в пакете", item.Key)
+				continue
+			}
+			keys[item.Key] = true
+		}
+
+		expr, err := h.newExpression(uuid.New().String(), &item.calculateRequest)
+		if err != nil {
+			batch.Items[i].Error = err.Error()
+			continue
+		}
+		batch.Items[i].ExpressionID = expr.ID
+		accepted[i] = expr
+	}
+
+	if err := h.storage.AddBatch(batch); err != nil {
+		http.Error(w, "Ошибка сохранения пакета", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(batch)
+
+	// Выражения пакета разбираются одно за другим, не занимая горутину на каждое
+	go func() {
+		for i, expr := range accepted {
+			if expr != nil {
+				h.processExpression(expr, expr.Expression, request.Expressions[i].options())
+			}
+		}
+	}()
+}
+
+// Обработчик получения хода вычисления пакета
+func (h *Handler) GetBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/batches/")
+	batch, exists := h.storage.GetBatch(id)
+	if !exists {
+		http.Error(w, "Пакет не найден", http.StatusNotFound)
+		return
+	}
+
+	status := batchStatus{
+		ID:        batch.ID,
+		Total:     len(batch.Items),
+		Progress:  map[string]int{"pending": 0, "processing": 0, "done": 0, "error": 0, "cancelled": 0},
+		Finished:  true,
+		Items:     make([]batchItemStatus, len(batch.Items)),
+		CreatedAt: batch.CreatedAt,
+	}
+	for i, item := range batch.Items {
+		itemStatus := &status.Items[i]
+		itemStatus.Key, itemStatus.ID, itemStatus.Error = item.Key, item.ExpressionID, item.Error
+		if item.ExpressionID == "" {
+			itemStatus.Status = "rejected"
+			status.Rejected++
+			continue
+		}
+
+		expr, ok := h.storage.GetExpression(item.ExpressionID)
+		if !ok {
+			itemStatus.Status = "deleted"
+			continue
+		}
+		itemStatus.Status, itemStatus.Error = expr.Status, expr.Error
+		if expr.Status == "done" {
+			result := expr.Result
+			itemStatus.Result = &result
+		}
+		status.Progress[expr.Status]++
+		if expr.Status == "pending" || expr.Status == "processing" {
+			status.Finished = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
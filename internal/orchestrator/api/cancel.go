@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"calc_service/pkg/errors"
+)
+
+// Обработчик отмены выражения: его задачи убираются из очереди, а результаты,
+// которые агенты пришлют позже, отклоняются
+func (h *Handler) CancelExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := expressionID(r)
+	switch err := h.storage.CancelExpression(id); err {
+	case nil:
+	case errors.ErrExpressionNotFound:
+		http.Error(w, "Выражение не найдено", http.StatusNotFound)
+		return
+	case errors.ErrExpressionFinished:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+
+	expr, _ := h.storage.GetExpression(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(expr)
+}
+
+// Обработчик удаления выражения вместе с задачами; вычисляющееся выражение сначала отменяется
+func (h *Handler) DeleteExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	switch err := h.storage.DeleteExpression(expressionID(r)); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.ErrExpressionNotFound:
+		http.Error(w, "Выражение не найдено", http.StatusNotFound)
+	default:
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"calc_service/internal/orchestrator/parser"
+	"calc_service/pkg/errors"
+	"calc_service/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+// derivativeRequest — запрос производной выражения по переменной
+type derivativeRequest struct {
+	Expression string             `json:"expression"`
+	Variable   string             `json:"variable"`
+	At         map[string]float64 `json:"at,omitempty"` // Точка, в которой нужно вычислить производную
+}
+
+type derivativeResponse struct {
+	Expression string `json:"expression"`
+	Variable   string `json:"variable"`
+	Derivative string `json:"derivative"`
+	ID         string `json:"id,omitempty"` // Выражение, вычисляющее производную в точке at
+}
+
+// Обработчик символьного дифференцирования. Если задана точка at, производная
+// в ней вычисляется агентами как обычное выражение
+func (h *Handler) DerivativeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request derivativeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Некорректный JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Expression == "" {
+		http.Error(w, errors.ErrEmptyExpression.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if !models.IsIdentifier(request.Variable) {
+		http.Error(w, fmt.Sprintf("%v: некорректное имя переменной %q", errors.ErrInvalidOption, request.Variable), http.StatusUnprocessableEntity)
+		return
+	}
+
+	d, err := parser.Derivative(request.Expression, request.Variable, parser.Options{Functions: h.storage.GetFunction})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	resp := derivativeResponse{
+		Expression: request.Expression,
+		Variable:   request.Variable,
+		Derivative: d.String(),
+	}
+	if request.At == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	newExpr := &models.Expression{
+		ID:         uuid.New().String(),
+		Expression: resp.Derivative,
+		Status:     "pending",
+		CreatedAt:  time.Now(),
+	}
+	if err := h.storage.AddExpression(newExpr); err != nil {
+		http.Error(w, "Ошибка сохранения выражения", http.StatusInternalServerError)
+		return
+	}
+	resp.ID = newExpr.ID
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+
+	go h.processExpression(newExpr, resp.Derivative, parser.Options{Variables: request.At})
+}
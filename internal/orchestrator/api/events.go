@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"calc_service/internal/orchestrator/events"
+	"calc_service/pkg/models"
+)
+
+// heartbeatInterval — как часто отправлять комментарий, чтобы прокси не закрывали простаивающий поток
+const heartbeatInterval = 15 * time.Second
+
+// SetEventHub задаёт хаб, из которого читаются потоки событий
+func (h *Handler) SetEventHub(hub *events.Hub) {
+	h.events = hub
+}
+
+// Обработчик потока событий всех выражений (Server-Sent Events)
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.events == nil {
+		http.Error(w, "Поток событий не настроен", http.StatusServiceUnavailable)
+		return
+	}
+
+	sub := h.events.Subscribe("")
+	defer h.events.Unsubscribe(sub)
+	h.streamEvents(w, r, sub, nil)
+}
+
+// Обработчик потока событий одного выражения: сначала текущее состояние, затем изменения.
+// Поток закрывается, когда выражение завершено или отменено
+func (h *Handler) ExpressionEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.events == nil {
+		http.Error(w, "Поток событий не настроен", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Подписываемся до чтения состояния, чтобы не пропустить изменение между ними
+	id := expressionID(r)
+	sub := h.events.Subscribe(id)
+	defer h.events.Unsubscribe(sub)
+
+	expr, exists := h.storage.GetExpression(id)
+	if !exists {
+		http.Error(w, "Выражение не найдено", http.StatusNotFound)
+		return
+	}
+	snapshot := *expr
+	h.streamEvents(w, r, sub, &snapshot)
+}
+
+// streamEvents пишет события подписки в формате text/event-stream. Если задано выражение,
+// поток начинается с его состояния и заканчивается вместе с его вычислением
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request, sub *events.Subscription, expr *models.Expression) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if expr != nil {
+		writeEvent(w, events.Event{Type: events.TypeStatus, ExpressionID: expr.ID, Status: expr.Status, Expression: expr, Time: expr.UpdatedAt})
+		if finished(expr.Status) {
+			if expr.Status != "cancelled" {
+				writeEvent(w, events.Event{Type: events.TypeResult, ExpressionID: expr.ID, Status: expr.Status, Expression: expr, Time: expr.UpdatedAt})
+			}
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-sub.C:
+			// Канал закрыт: клиент не успевал забирать события и должен переподключиться
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+			if expr != nil && (event.Type == events.TypeResult || event.Status == "cancelled") {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) {
+	data, _ := json.Marshal(event)
+	if event.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+// finished сообщает, что выражение больше не изменится
+func finished(status string) bool {
+	return status == "done" || status == "error" || status == "cancelled"
+}
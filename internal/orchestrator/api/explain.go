@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"calc_service/internal/orchestrator/parser"
+)
+
+// explanation — ответ на запрос разбора выражения
+type explanation struct {
+	Expression string       `json:"expression"`
+	RPN        []string     `json:"rpn"`            // Обратная польская запись
+	AST        *parser.Node `json:"ast"`            // Синтаксическое дерево записи
+	Tree       *parser.Node `json:"tree,omitempty"` // Дерево, по которому построены задачи
+	Shape      []int        `json:"shape,omitempty"`
+	Unit       string       `json:"unit,omitempty"` // Единица результата
+	*parser.Graph
+}
+
+// Обработчик разбора выражения без вычисления
+func (h *Handler) ExplainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request calculateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Некорректный JSON", http.StatusBadRequest)
+		return
+	}
+	if err := request.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	opts := request.options()
+	opts.Functions = h.storage.GetFunction
+	opts.Rates = h.rates
+	plan, err := parser.Compile(request.Expression, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(explanation{
+		Expression: request.Expression,
+		RPN:        plan.RPN,
+		AST:        plan.AST,
+		Tree:       plan.Tree,
+		Shape:      plan.Shape,
+		Unit:       plan.Unit,
+		Graph:      parser.BuildGraph(plan.Tasks),
+	})
+}
+
+// Обработчик получения плана вычисления сохранённого выражения:
+// граф строится по задачам, которые действительно выполняют агенты
+func (h *Handler) GetExpressionPlanHandler(w http.ResponseWriter, r *http.Request) {
+	expr, exists := h.storage.GetExpression(expressionID(r))
+	if !exists {
+		http.Error(w, "Выражение не найдено", http.StatusNotFound)
+		return
+	}
+
+	tasks, err := h.storage.GetExpressionTasks(expr.ID)
+	if err != nil {
+		http.Error(w, "Ошибка получения данных", http.StatusInternalServerError)
+		return
+	}
+
+	resp := explanation{
+		Expression: expr.Expression,
+		Shape:      expr.Shape,
+		Unit:       expr.Unit,
+		Graph:      parser.BuildGraph(tasks),
+	}
+	// Запись, которую не удалось разобрать, отдаётся без дерева
+	if rpn, ast, err := parser.Read(expr.Expression, expr.Syntax); err == nil {
+		resp.RPN, resp.AST = rpn, ast
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeExpressionGraph отдаёт граф задач выражения в формате Graphviz DOT или Mermaid
+func (h *Handler) writeExpressionGraph(w http.ResponseWriter, exprID, format string) {
+	tasks, err := h.storage.GetExpressionTasks(exprID)
+	if err != nil {
+		http.Error(w, "Ошибка получения данных", http.StatusInternalServerError)
+		return
+	}
+
+	graph := parser.BuildGraph(tasks)
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		io.WriteString(w, graph.DOT())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, graph.Mermaid())
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"calc_service/internal/orchestrator/parser"
+	"calc_service/pkg/errors"
+)
+
+// formatRequest — запрос на форматирование выражения
+type formatRequest struct {
+	Expression string `json:"expression"`
+	Syntax     string `json:"syntax"` // Нотация записи: infix (по умолчанию) или latex
+}
+
+// Обработчик форматирования: каноническая запись выражения, LaTeX и MathML
+func (h *Handler) FormatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request formatRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Некорректный JSON", http.StatusBadRequest)
+		return
+	}
+	if request.Expression == "" {
+		http.Error(w, errors.ErrEmptyExpression.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	formatted, err := parser.Format(request.Expression, request.Syntax)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Expression string `json:"expression"`
+		*parser.Formatted
+	}{request.Expression, formatted})
+}
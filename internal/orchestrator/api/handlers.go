@@ -1,11 +1,19 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"calc_service/internal/orchestrator/events"
+	"calc_service/internal/orchestrator/parser"
+	"calc_service/internal/orchestrator/rates"
 	"calc_service/internal/orchestrator/storage"
 	"calc_service/pkg/errors"
 	"calc_service/pkg/models"
@@ -13,14 +21,42 @@ import (
 	"github.com/google/uuid"
 )
 
+// AgentIDHeader — заголовок, которым агент представляется при получении задачи
+const AgentIDHeader = "X-Agent-ID"
+
+// TenantHeader — заголовок с именем клиента, отправляющего выражения. Агенты делятся между
+// клиентами поровну или по весам, так что большие пакеты одного клиента не задерживают других
+const TenantHeader = "X-Tenant-ID"
+
+// maxPriority — наибольший приоритет выражения
+const maxPriority = 9
+
+// maxTimeout — наибольший срок вычисления выражения в timeout_ms
+const maxTimeout = 24 * time.Hour
+
+// IdempotencyKeyHeader — заголовок с ключом, по которому повтор запроса на вычисление
+// возвращает уже созданное выражение; IdempotentReplayedHeader отмечает такой ответ
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
 type Handler struct {
 	storage storage.Storage
+	rates   rates.RateProvider
+	events  *events.Hub
 }
 
 func NewHandler(store storage.Storage) *Handler {
 	return &Handler{storage: store}
 }
 
+// SetRateProvider задаёт курсы валют для выражений с денежными суммами
+func (h *Handler) SetRateProvider(provider rates.RateProvider) {
+	h.rates = provider
+}
+
 // Обработчик добавления выражения
 func (h *Handler) CalculateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -28,43 +64,216 @@ func (h *Handler) CalculateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var request struct {
-		Expression string `json:"expression"`
+	// sync=true — ответить готовым выражением, дождавшись результата не дольше wait
+	sync := r.URL.Query().Get("sync") == "true"
+	wait, err := parseWait(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sync && wait == 0 {
+		wait = defaultSyncWait
 	}
 
+	var request calculateRequest
+
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Некорректный JSON", http.StatusBadRequest)
 		return
 	}
 
-	if request.Expression == "" {
-		http.Error(w, "Пустое выражение", http.StatusUnprocessableEntity)
+	request.Tenant = r.Header.Get(TenantHeader)
+	if err := h.checkRequest(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
 
-	// Генерация ID выражения
-	exprID := uuid.New().String()
-
-	// Создаем новое выражение
-	newExpr := &models.Expression{
-		ID:        exprID,
-		Status:    "pending",
-		CreatedAt: time.Now(),
+	// Повтор запроса с тем же ключом возвращает уже созданное выражение
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if len(key) > maxIdempotencyKeyLength {
+		http.Error(w, fmt.Sprintf("%s длиннее %d символов", IdempotencyKeyHeader, maxIdempotencyKeyLength), http.StatusBadRequest)
+		return
+	}
+	newExpr := request.expression(uuid.New().String())
+	if key == "" {
+		if err := h.storage.AddExpression(newExpr); err != nil {
+			http.Error(w, "Ошибка сохранения выражения", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		claimed, created, err := h.storage.AddExpressionWithKey(newExpr, &models.IdempotencyKey{
+			Key:          key,
+			Tenant:       request.Tenant,
+			Fingerprint:  request.fingerprint(),
+			ExpressionID: newExpr.ID,
+			CreatedAt:    newExpr.CreatedAt,
+		})
+		if err != nil {
+			http.Error(w, "Ошибка сохранения выражения", http.StatusInternalServerError)
+			return
+		}
+		if !created {
+			if claimed.Fingerprint != request.fingerprint() {
+				http.Error(w, errors.ErrIdempotencyConflict.Error(), http.StatusConflict)
+				return
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			if sync {
+				h.writeSyncResult(w, r, claimed.ExpressionID, wait)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"id": claimed.ExpressionID})
+			return
+		}
 	}
 
-	// Сохраняем в хранилище
-	if err := h.storage.AddExpression(newExpr); err != nil {
-		http.Error(w, "Ошибка сохранения выражения", http.StatusInternalServerError)
+	// Запускаем обработку выражения в фоне
+	go h.processExpression(newExpr, request.Expression, request.options())
+	if sync {
+		h.writeSyncResult(w, r, newExpr.ID, wait)
 		return
 	}
 
 	// Отправляем ответ
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": exprID})
+	json.NewEncoder(w).Encode(map[string]string{"id": newExpr.ID})
+}
 
-	// Запускаем обработку выражения в фоне
-	go h.processExpression(newExpr, request.Expression)
+// writeSyncResult отвечает на синхронный запрос выражением: 200 — оно завершено,
+// 202 — за wait не успело, и результат нужно получить позже по ID
+func (h *Handler) writeSyncResult(w http.ResponseWriter, r *http.Request, id string, wait time.Duration) {
+	expr, finished := h.awaitExpression(r.Context(), id, wait)
+	if expr == nil {
+		http.Error(w, "Выражение не найдено", http.StatusNotFound)
+		return
+	}
+
+	status := http.StatusOK
+	if !finished {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(expr)
+}
+
+// newExpression проверяет запрос и сохраняет новое выражение с ID id. Ошибки проверки
+// возвращаются клиенту со статусом 422; errors.ErrInternalServerError — выражение не сохранено
+func (h *Handler) newExpression(id string, request *calculateRequest) (*models.Expression, error) {
+	if err := h.checkRequest(request); err != nil {
+		return nil, err
+	}
+	return h.saveExpression(id, request)
+}
+
+// checkRequest проверяет запрос до создания выражения
+func (h *Handler) checkRequest(request *calculateRequest) error {
+	if err := request.validate(); err != nil {
+		return err
+	}
+
+	// Ошибки в вызовах функций, погрешностях и единицах измерения сообщаем сразу,
+	// не дожидаясь обработки
+	opts := request.options()
+	opts.Functions = h.storage.GetFunction
+	opts.Rates = h.rates
+	return parser.Check(request.Expression, opts)
+}
+
+func (h *Handler) saveExpression(id string, request *calculateRequest) (*models.Expression, error) {
+	expr := request.expression(id)
+	if err := h.storage.AddExpression(expr); err != nil {
+		return nil, errors.ErrInternalServerError
+	}
+	return expr, nil
+}
+
+// expression создаёт по запросу новое выражение с ID id
+func (req *calculateRequest) expression(id string) *models.Expression {
+	now := time.Now()
+	expr := &models.Expression{
+		ID:             id,
+		Expression:     req.Expression,
+		Syntax:         req.Syntax,
+		Mode:           req.Mode,
+		Status:         "pending",
+		CallbackURL:    req.CallbackURL,
+		CallbackSecret: req.CallbackSecret,
+		Priority:       req.Priority,
+		Tenant:         req.Tenant,
+		CreatedAt:      now,
+	}
+	if req.TimeoutMS > 0 {
+		deadline := now.Add(time.Duration(req.TimeoutMS) * time.Millisecond)
+		expr.Deadline = &deadline
+	}
+	return expr
+}
+
+// calculateRequest — тело запроса на вычисление или разбор выражения
+type calculateRequest struct {
+	Expression string `json:"expression"`
+	Syntax     string `json:"syntax"`   // Нотация записи: infix (по умолчанию) или latex
+	Mode       string `json:"mode"`     // Режим вычисления: пусто — числа, interval — интервалы
+	Optimize   int    `json:"optimize"` // Уровень оптимизации дерева, 0 — без оптимизаций
+	// Не переставлять операции ради параллельности — для воспроизводимости результата
+	PreserveOrder bool `json:"preserve_order"`
+	// Куда отправить результат после завершения и секрет для подписи запроса
+	CallbackURL    string `json:"callback_url"`
+	CallbackSecret string `json:"callback_secret"`
+	Priority       int    `json:"priority"` // От 0 до maxPriority; задачи с большим приоритетом выдаются раньше
+	Tenant         string `json:"-"`        // Клиент из TenantHeader
+	// Срок вычисления в мс: не вычисленное к сроку выражение завершается с ошибкой; 0 — без срока
+	TimeoutMS int `json:"timeout_ms"`
+}
+
+// validate проверяет запрос; ошибка возвращается клиенту со статусом 422
+func (req *calculateRequest) validate() error {
+	if req.Expression == "" {
+		return errors.ErrEmptyExpression
+	}
+	if req.Syntax != "" && req.Syntax != parser.SyntaxInfix && req.Syntax != parser.SyntaxLaTeX {
+		return fmt.Errorf("%w: syntax должен быть %s или %s", errors.ErrInvalidOption, parser.SyntaxInfix, parser.SyntaxLaTeX)
+	}
+	if req.Mode != "" && req.Mode != parser.ModeInterval {
+		return fmt.Errorf("%w: mode должен быть пустым или %s", errors.ErrInvalidOption, parser.ModeInterval)
+	}
+	if req.Optimize < parser.OptimizeNone || req.Optimize > parser.OptimizeMax {
+		return fmt.Errorf("%w: optimize должен быть от %d до %d", errors.ErrInvalidOption, parser.OptimizeNone, parser.OptimizeMax)
+	}
+	if req.Priority < 0 || req.Priority > maxPriority {
+		return fmt.Errorf("%w: priority должен быть от 0 до %d", errors.ErrInvalidOption, maxPriority)
+	}
+	if req.TimeoutMS < 0 || int64(req.TimeoutMS) > maxTimeout.Milliseconds() {
+		return fmt.Errorf("%w: timeout_ms должен быть от 0 до %d", errors.ErrInvalidOption, maxTimeout.Milliseconds())
+	}
+	if req.CallbackURL != "" {
+		if u, err := url.Parse(req.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: callback_url должен быть адресом http или https", errors.ErrInvalidOption)
+		}
+	} else if req.CallbackSecret != "" {
+		return fmt.Errorf("%w: callback_secret без callback_url", errors.ErrInvalidOption)
+	}
+	return nil
+}
+
+// fingerprint — отпечаток запроса для проверки повторов с тем же ключом идемпотентности
+func (req *calculateRequest) fingerprint() string {
+	body, _ := json.Marshal(req)
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (req *calculateRequest) options() parser.Options {
+	return parser.Options{
+		Syntax:        req.Syntax,
+		Mode:          req.Mode,
+		Optimize:      req.Optimize,
+		PreserveOrder: req.PreserveOrder,
+	}
 }
 
 func (h *Handler) TaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -78,42 +287,115 @@ func (h *Handler) TaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Обработчик получения списка выражений
+// Обработчик получения списка выражений: фильтры, сортировка и постраничная выдача по курсору
 func (h *Handler) GetExpressionsHandler(w http.ResponseWriter, r *http.Request) {
-	expressions, err := h.storage.GetAllExpressions()
+	query, err := parseExpressionQuery(r.URL.Query())
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.storage.QueryExpressions(query)
+	if err != nil {
+		if err == errors.ErrInvalidCursor {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Ошибка получения данных", http.StatusInternalServerError)
 		return
 	}
 
+	resp := map[string]interface{}{
+		"expressions": page.Expressions,
+	}
+	if page.NextCursor != "" {
+		resp["next_cursor"] = page.NextCursor
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"expressions": expressions,
-	})
+	w.Header().Set(TotalCountHeader, strconv.Itoa(page.Total))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Маршрутизация запросов к /api/v1/expressions/{id} и его вложенным ресурсам
+func (h *Handler) ExpressionHandler(w http.ResponseWriter, r *http.Request) {
+	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(pathParts) == 4 {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetExpressionHandler(w, r)
+		case http.MethodDelete:
+			h.DeleteExpressionHandler(w, r)
+		default:
+			http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	switch pathParts[len(pathParts)-1] {
+	case "cancel":
+		h.CancelExpressionHandler(w, r)
+	case "deliveries":
+		h.GetDeliveriesHandler(w, r)
+	case "events":
+		h.ExpressionEventsHandler(w, r)
+	case "plan":
+		h.GetExpressionPlanHandler(w, r)
+	case "trace":
+		h.GetExpressionTraceHandler(w, r)
+	default:
+		http.Error(w, "Ресурс не найден", http.StatusNotFound)
+	}
 }
 
 // Обработчик получения выражения по ID
 func (h *Handler) GetExpressionHandler(w http.ResponseWriter, r *http.Request) {
-	pathParts := strings.Split(r.URL.Path, "/")
-	if len(pathParts) < 4 {
+	exprID := expressionID(r)
+	if exprID == "" {
 		http.Error(w, "Некорректный URL", http.StatusBadRequest)
 		return
 	}
 
-	exprID := pathParts[3]
+	wait, err := parseWait(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	expr, exists := h.storage.GetExpression(exprID)
+	if exists && wait > 0 {
+		// Длинный опрос: отвечаем, когда выражение завершится или истечёт wait
+		expr, _ = h.awaitExpression(r.Context(), exprID, wait)
+		exists = expr != nil
+	}
 	if !exists {
 		http.Error(w, "Выражение не найдено", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(expr)
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(expr)
+	case "dot", "mermaid":
+		h.writeExpressionGraph(w, expr.ID, format)
+	default:
+		http.Error(w, "Неизвестный формат: "+format, http.StatusBadRequest)
+	}
+}
+
+// expressionID извлекает ID выражения из пути /api/v1/expressions/{id}[/...]
+func expressionID(r *http.Request) string {
+	pathParts := strings.Split(r.URL.Path, "/")
+	if len(pathParts) < 5 {
+		return ""
+	}
+	return pathParts[4]
 }
 
 // Обработчик получения задачи для агента
 func (h *Handler) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
-	task, err := h.storage.GetNextTask()
+	task, err := h.storage.GetNextTaskForAgent(r.Header.Get(AgentIDHeader))
 	if err != nil {
 		if err == errors.ErrTaskNotFound {
 			w.WriteHeader(http.StatusNotFound)
@@ -123,20 +405,13 @@ func (h *Handler) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Обновляем статус задачи
-	task.Status = "processing"
-	h.storage.UpdateTask(task)
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(task)
 }
 
 // Обработчик отправки результата задачи
 func (h *Handler) SubmitTaskResultHandler(w http.ResponseWriter, r *http.Request) {
-	var result struct {
-		TaskID string  `json:"task_id"`
-		Result float64 `json:"result"`
-	}
+	var result models.TaskResult
 
 	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
 		http.Error(w, "Некорректный JSON", http.StatusBadRequest)
@@ -144,30 +419,160 @@ func (h *Handler) SubmitTaskResultHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Обновляем задачу и выражение
-	if err := h.storage.CompleteTask(result.TaskID, result.Result); err != nil {
-		if err == errors.ErrTaskNotFound {
+	var err error
+	switch {
+	case result.Error != "":
+		err = h.storage.FailTask(result.TaskID, result.Error)
+	case result.Results != nil:
+		err = h.storage.CompleteArrayTask(result.TaskID, result.Results)
+	default:
+		err = h.storage.CompleteTask(result.TaskID, result.Result)
+	}
+	if err != nil {
+		switch err {
+		case errors.ErrTaskNotFound:
 			http.Error(w, "Задача не найдена", http.StatusNotFound)
-			return
+		case errors.ErrExpressionCancelled, errors.ErrTimeout, errors.ErrExpressionFailed:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
 		}
-		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// Обработчик пользовательских функций
+func (h *Handler) FunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetFunctionsHandler(w, r)
+	case http.MethodPost:
+		h.CreateFunctionHandler(w, r)
+	default:
+		http.Error(w, "Метод не разрешён", http.StatusMethodNotAllowed)
+	}
+}
+
+// Обработчик регистрации пользовательской функции
+func (h *Handler) CreateFunctionHandler(w http.ResponseWriter, r *http.Request) {
+	var fn models.Function
+	if err := json.NewDecoder(r.Body).Decode(&fn); err != nil {
+		http.Error(w, "Некорректный JSON", http.StatusBadRequest)
+		return
+	}
+	if fn.Params == nil {
+		fn.Params = []string{}
+	}
+
+	// Тело должно разбираться и не должно вызывать функцию рекурсивно
+	if err := parser.ValidateFunction(&fn, h.storage.GetFunction); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	fn.CreatedAt = time.Now()
+	if err := h.storage.AddFunction(&fn); err != nil {
+		if err == errors.ErrFunctionExists {
+			http.Error(w, "Функция уже существует", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Ошибка сохранения функции", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(fn)
+}
+
+// Обработчик получения списка пользовательских функций
+func (h *Handler) GetFunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	functions, err := h.storage.GetAllFunctions()
+	if err != nil {
+		http.Error(w, "Ошибка получения данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"functions": functions,
+	})
+}
+
 // Внутренняя логика обработки выражения
-func (h *Handler) processExpression(expr *models.Expression, rawExpr string) {
-	// Заглушка для парсинга выражения
-	// Реальная реализация будет разбивать выражение на задачи
-	// Пример временной реализации:
+func (h *Handler) processExpression(expr *models.Expression, rawExpr string, opts parser.Options) {
+	// Разбираем выражение, раскрывая пользовательские функции
+	opts.Functions = h.storage.GetFunction
+	opts.Rates = h.rates
+	plan, err := parser.Compile(rawExpr, opts)
+	if err != nil {
+		expr.Status = "error"
+		expr.Error = err.Error()
+		expr.UpdatedAt = time.Now()
+		h.storage.UpdateExpression(expr)
+		return
+	}
 
-	// Имитация долгой обработки
-	time.Sleep(2 * time.Second)
+	expr.Unit = plan.Unit
+	expr.ResultType = plan.Type
+
+	// Результат-список, матрица или границы интервала собираются из выходов плана
+	// по мере выполнения задач
+	if plan.Shape != nil || plan.Interval {
+		expr.Shape = plan.Shape
+		expr.Results = make([]float64, len(plan.Outputs))
+		expr.ResultTasks = make([]string, len(plan.Outputs))
+		expr.ResultIndex = make([]int, len(plan.Outputs))
+		for i, out := range plan.Outputs {
+			expr.Results[i], expr.ResultTasks[i], expr.ResultIndex[i] = out.Value, out.Task, out.Index
+		}
+	}
 
-	// Обновляем статус
-	expr.Status = "done"
-	expr.Result = 42 // Пример результата
+	// Выражение без операций вычислять агентам не нужно
+	if len(plan.Tasks) == 0 {
+		expr.Status = "done"
+		if value, ok := plan.Constant(); ok {
+			expr.Result = value
+		}
+		expr.FormatResult()
+		expr.UpdatedAt = time.Now()
+		h.storage.UpdateExpression(expr)
+		return
+	}
 
+	expr.Status = "processing"
+	expr.UpdatedAt = time.Now()
 	h.storage.UpdateExpression(expr)
+
+	// Ставим задачи в очередь все разом; зависимые ждут результатов своих операндов
+	if err := h.storage.AddTasks(expr.ID, plan.Tasks); err != nil {
+		// Выражение отменили, удалили или истёк его срок, пока оно разбиралось
+		if err == errors.ErrExpressionCancelled || err == errors.ErrTimeout || err == errors.ErrExpressionNotFound {
+			return
+		}
+		expr.Status = "error"
+		expr.Error = err.Error()
+		expr.UpdatedAt = time.Now()
+		h.storage.UpdateExpression(expr)
+	}
+}
+
+// Обработчик получения попыток доставки результата по callback_url
+func (h *Handler) GetDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := h.storage.GetDeliveries(expressionID(r))
+	if err != nil {
+		if err == errors.ErrExpressionNotFound {
+			http.Error(w, "Выражение не найдено", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Ошибка получения данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deliveries": deliveries,
+	})
 }
@@ -1,13 +1,22 @@
 package api_test
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"calc_service/internal/orchestrator/api"
+	"calc_service/internal/orchestrator/events"
 	"calc_service/internal/orchestrator/storage"
 	"calc_service/pkg/models"
 )
@@ -28,6 +37,18 @@ func TestCalculateHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("Неизвестный уровень оптимизации", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "2+2", "optimize": 5}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Ожидался статус 422, получен %d", w.Code)
+		}
+	})
+
 	t.Run("Пустое выражение", func(t *testing.T) {
 		body := bytes.NewBufferString(`{"expression": ""}`)
 		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
@@ -39,6 +60,294 @@ func TestCalculateHandler(t *testing.T) {
 			t.Errorf("Ожидался статус 422, получен %d", w.Code)
 		}
 	})
+
+	t.Run("Вызов без аргументов", func(t *testing.T) {
+		store.AddFunction(&models.Function{Name: "pi", Params: []string{}, Body: "3.14159"})
+
+		for _, body := range []string{
+			`{"expression": "sqrt()"}`,
+			`{"expression": "sum()"}`,
+			`{"expression": "count()"}`,
+			`{"expression": "weekday()"}`,
+			`{"expression": "foo()"}`,
+			`{"expression": "pi(1)"}`,
+			`{"expression": "\\operatorname{sqrt}()", "syntax": "latex"}`,
+		} {
+			w := httptest.NewRecorder()
+			handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(body)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("%s: ожидался статус 422, получен %d", body, w.Code)
+			}
+
+			w = httptest.NewRecorder()
+			handler.ExplainHandler(w, httptest.NewRequest("POST", "/api/v1/explain", bytes.NewBufferString(body)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("%s: ожидался статус 422 от explain, получен %d", body, w.Code)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate?sync=true", bytes.NewBufferString(`{"expression": "pi()"}`)))
+		var expr models.Expression
+		json.NewDecoder(w.Body).Decode(&expr)
+		if w.Code != http.StatusOK || expr.Status != "done" || expr.Result != 3.14159 {
+			t.Errorf("Ожидалось значение pi(), получено %d %s %v", w.Code, expr.Status, expr.Result)
+		}
+	})
+
+	t.Run("Неизвестный синтаксис", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "2+2", "syntax": "asciimath"}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Ожидался статус 422, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Формула LaTeX", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "\\frac{3}{4} \\cdot \\sqrt{16} + 2^{3}", "syntax": "latex"}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if task, err := store.GetNextTask(); err == nil {
+				store.CompleteTask(task.ID, evaluate(task))
+				continue
+			}
+			if expr, _ := store.GetExpression(created.ID); expr.Status == "done" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		expr, _ := store.GetExpression(created.ID)
+		if expr.Status != "done" || expr.Result != 11 || expr.Syntax != "latex" {
+			t.Errorf("Ожидался результат 11, получен %s %v", expr.Status, expr.Result)
+		}
+	})
+
+	t.Run("Несовместимые единицы", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "2 m + 3 s"}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Ожидался статус 422, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Величины с единицами", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "5 m / 2 s to km/h"}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if task, err := store.GetNextTask(); err == nil {
+				store.CompleteTask(task.ID, evaluate(task))
+				continue
+			}
+			if expr, _ := store.GetExpression(created.ID); expr.Status == "done" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		expr, _ := store.GetExpression(created.ID)
+		if expr.Status != "done" || math.Abs(expr.Result-9) > 1e-9 || expr.Unit != "km/h" {
+			t.Errorf("Ожидался результат 9 km/h, получен %s %v %s", expr.Status, expr.Result, expr.Unit)
+		}
+	})
+
+	t.Run("Дата и длительность", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "2026-10-18 + 3d 4h"}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if task, err := store.GetNextTask(); err == nil {
+				store.CompleteTask(task.ID, evaluate(task))
+				continue
+			}
+			if expr, _ := store.GetExpression(created.ID); expr.Status == "done" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		expr, _ := store.GetExpression(created.ID)
+		if expr.Status != "done" || expr.ResultType != models.ResultDate || expr.Value != "2026-10-21T04:00:00Z" {
+			t.Errorf("Ожидалась дата 2026-10-21T04:00:00Z, получено %s %s %q", expr.Status, expr.ResultType, expr.Value)
+		}
+	})
+
+	t.Run("Неизвестный режим", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "2+2", "mode": "fuzzy"}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Ожидался статус 422, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Интервальный режим", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "[1, 2] + 3 ± 1", "mode": "interval"}`)
+		req := httptest.NewRequest("POST", "/api/v1/calculate", body)
+		w := httptest.NewRecorder()
+
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if task, err := store.GetNextTask(); err == nil {
+				if !task.Interval || task.Operation != "+" {
+					t.Fatalf("Ожидалась интервальная сумма, получена %+v", task)
+				}
+				store.CompleteArrayTask(task.ID, []float64{task.Args[0] + task.Args[2], task.Args[1] + task.Args[3]})
+				continue
+			}
+			if expr, _ := store.GetExpression(created.ID); expr.Status == "done" {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		expr, _ := store.GetExpression(created.ID)
+		if expr.Status != "done" || expr.Mode != "interval" || len(expr.Results) != 2 {
+			t.Fatalf("Ожидались границы интервала, получено %s %v", expr.Status, expr.Results)
+		}
+		if expr.Results[0] > 3 || expr.Results[1] < 6 {
+			t.Errorf("Интервал %v должен содержать [3, 6]", expr.Results)
+		}
+	})
+
+	t.Run("Погрешность вне интервального режима", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "1 ± 0.1"}`)
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate", body))
+
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "interval") {
+			t.Errorf("Ожидался статус 422 с упоминанием interval, получен %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Адрес для результата", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "2+2", "callback_url": "http://example.com/hook", "callback_secret": "s"}`)
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate", body))
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+		expr, _ := store.GetExpression(created.ID)
+		if w.Code != http.StatusCreated || expr.CallbackURL != "http://example.com/hook" || expr.CallbackSecret != "s" {
+			t.Fatalf("Ожидался статус 201 и сохранённый адрес, получен %d", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ExpressionHandler(w, httptest.NewRequest("GET", "/api/v1/expressions/"+created.ID+"/deliveries", nil))
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"s"`) {
+			t.Errorf("Неожиданный ответ %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Приоритет и клиент", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "2+2", "priority": 7}`))
+		req.Header.Set(api.TenantHeader, "web")
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+		expr, _ := store.GetExpression(created.ID)
+		if w.Code != http.StatusCreated || expr.Priority != 7 || expr.Tenant != "web" {
+			t.Errorf("Ожидались приоритет 7 и клиент web, получено %d %d %q", w.Code, expr.Priority, expr.Tenant)
+		}
+
+		w = httptest.NewRecorder()
+		handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "2+2", "priority": 10}`)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Ожидался статус 422, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Срок вычисления", func(t *testing.T) {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate?sync=true", bytes.NewBufferString(`{"expression": "2+2", "timeout_ms": 50}`)))
+
+		// Задачу никто не выполняет: выражение завершается ошибкой по истечении срока
+		var expr models.Expression
+		json.NewDecoder(w.Body).Decode(&expr)
+		if w.Code != http.StatusOK || expr.Status != "error" || expr.Error != "превышено время выполнения" || expr.Deadline == nil {
+			t.Fatalf("Ожидалась ошибка по сроку, получено %d %s %q", w.Code, expr.Status, expr.Error)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+			t.Errorf("Ответ через %s, ожидалось около 50ms", elapsed)
+		}
+
+		// Срок, который при переводе в time.Duration переполнился бы, тоже отклоняется
+		for _, timeout := range []string{"-1", "86400001", "9223372036854776"} {
+			w = httptest.NewRecorder()
+			handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "2+2", "timeout_ms": `+timeout+`}`)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("timeout_ms %s: ожидался статус 422, получен %d", timeout, w.Code)
+			}
+		}
+	})
+
+	for _, body := range []string{
+		`{"expression": "2+2", "callback_url": "ftp://example.com"}`,
+		`{"expression": "2+2", "callback_url": "/hook"}`,
+		`{"expression": "2+2", "callback_secret": "s"}`,
+	} {
+		t.Run("Некорректный адрес для результата", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(body)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("%s: ожидался статус 422, получен %d", body, w.Code)
+			}
+		})
+	}
 }
 
 func TestGetExpressionHandler(t *testing.T) {
@@ -76,3 +385,993 @@ func TestGetExpressionHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestFunctionsHandler(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"Регистрация функции", `{"name":"hyp","params":["a","b"],"body":"sqrt(a*a+b*b)"}`, http.StatusCreated},
+		{"Повторная регистрация", `{"name":"hyp","params":["a","b"],"body":"a+b"}`, http.StatusConflict},
+		{"Рекурсивная функция", `{"name":"f","params":["x"],"body":"f(x)+1"}`, http.StatusUnprocessableEntity},
+		{"Неизвестный параметр", `{"name":"g","params":["x"],"body":"x+y"}`, http.StatusUnprocessableEntity},
+		{"Некорректный JSON", `{"name":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/functions", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.FunctionsHandler(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Ожидался статус %d, получен %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	t.Run("Вычисление с пользовательской функцией", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "hyp(3,4)*2"}`))
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+
+		// Выполняем задачи вместо агента
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			task, err := store.GetNextTask()
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			store.CompleteTask(task.ID, evaluate(task))
+			if expr, _ := store.GetExpression(created.ID); expr.Status == "done" {
+				break
+			}
+		}
+
+		expr, _ := store.GetExpression(created.ID)
+		if expr.Status != "done" || expr.Result != 10 {
+			t.Errorf("Ожидался результат 10, получен %s %v", expr.Status, expr.Result)
+		}
+	})
+}
+
+// evaluate выполняет задачу так же, как агент, но без задержки
+func evaluate(task *models.Task) float64 {
+	switch task.Operation {
+	case "+":
+		return task.Arg1 + task.Arg2
+	case "-":
+		return task.Arg1 - task.Arg2
+	case "*":
+		return task.Arg1 * task.Arg2
+	case "/":
+		return task.Arg1 / task.Arg2
+	case "sqrt":
+		return math.Sqrt(task.Arg1)
+	}
+	return 0
+}
+
+func TestExplainHandler(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	t.Run("Разбор выражения", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "1+2+3+4"}`)
+		req := httptest.NewRequest("POST", "/api/v1/explain", body)
+		w := httptest.NewRecorder()
+
+		handler.ExplainHandler(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Ожидался статус 200, получен %d", w.Code)
+		}
+		var resp struct {
+			RPN            []string `json:"rpn"`
+			Tasks          []any    `json:"tasks"`
+			CriticalPathMs int      `json:"critical_path_ms"`
+			MaxParallelism int      `json:"max_parallelism"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.RPN) != 7 || len(resp.Tasks) != 3 || resp.CriticalPathMs != 2000 || resp.MaxParallelism != 2 {
+			t.Errorf("Неожиданный план: %+v", resp)
+		}
+	})
+
+	t.Run("Некорректное выражение", func(t *testing.T) {
+		body := bytes.NewBufferString(`{"expression": "1+"}`)
+		req := httptest.NewRequest("POST", "/api/v1/explain", body)
+		w := httptest.NewRecorder()
+
+		handler.ExplainHandler(w, req)
+
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Ожидался статус 422, получен %d", w.Code)
+		}
+	})
+
+	t.Run("План сохранённого выражения", func(t *testing.T) {
+		store.AddExpression(&models.Expression{ID: "planned", Expression: "2*3", Status: "processing"})
+		store.AddTask(&models.Task{ID: "t1", ExpressionID: "planned", Operation: "*", Arg1: 2, Arg2: 3, OperationTime: 2000})
+
+		req := httptest.NewRequest("GET", "/api/v1/expressions/planned/plan", nil)
+		w := httptest.NewRecorder()
+
+		handler.ExpressionHandler(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Ожидался статус 200, получен %d", w.Code)
+		}
+	})
+
+	t.Run("План несуществующего выражения", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/expressions/invalid/plan", nil)
+		w := httptest.NewRecorder()
+
+		handler.ExpressionHandler(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Ожидался статус 404, получен %d", w.Code)
+		}
+	})
+}
+
+func TestExpressionGraphFormats(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	store.AddExpression(&models.Expression{ID: "graph", Expression: "2*3", Status: "processing"})
+	store.AddTask(&models.Task{ID: "t1", ExpressionID: "graph", Operation: "*", Arg1: 2, Arg2: 3, OperationTime: 2000})
+
+	tests := []struct {
+		format      string
+		status      int
+		contentType string
+		prefix      string
+	}{
+		{"dot", http.StatusOK, "text/vnd.graphviz; charset=utf-8", "digraph expression {"},
+		{"mermaid", http.StatusOK, "text/plain; charset=utf-8", "flowchart LR"},
+		{"json", http.StatusOK, "application/json", `{"id":"graph"`},
+		{"svg", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/expressions/graph?format="+tt.format, nil)
+			w := httptest.NewRecorder()
+
+			handler.ExpressionHandler(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Ожидался статус %d, получен %d", tt.status, w.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Ожидался Content-Type %q, получен %q", tt.contentType, ct)
+			}
+			if !strings.HasPrefix(w.Body.String(), tt.prefix) {
+				t.Errorf("Неожиданное тело ответа: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestExpressionTrace(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	// submit отправляет выражение и выполняет его задачи через API агента
+	submit := func(expression string, execute func(*models.Task) models.TaskResult) string {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "`+expression+`"}`))
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if expr, ok := store.GetExpression(created.ID); ok && (expr.Status == "done" || expr.Status == "error") {
+				break
+			}
+			req := httptest.NewRequest("GET", "/internal/task", nil)
+			req.Header.Set(api.AgentIDHeader, "agent-1")
+			w := httptest.NewRecorder()
+			handler.TaskHandler(w, req)
+			if w.Code != http.StatusOK {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			var task models.Task
+			json.NewDecoder(w.Body).Decode(&task)
+			body, _ := json.Marshal(execute(&task))
+			handler.TaskHandler(httptest.NewRecorder(), httptest.NewRequest("POST", "/internal/task", bytes.NewReader(body)))
+		}
+		return created.ID
+	}
+
+	getTrace := func(id string) (int, map[string]any) {
+		req := httptest.NewRequest("GET", "/api/v1/expressions/"+id+"/trace", nil)
+		w := httptest.NewRecorder()
+		handler.ExpressionHandler(w, req)
+
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	t.Run("Шаги вычисления", func(t *testing.T) {
+		id := submit("(2+3)*4", func(task *models.Task) models.TaskResult {
+			return models.TaskResult{TaskID: task.ID, Result: evaluate(task)}
+		})
+
+		code, resp := getTrace(id)
+		if code != http.StatusOK {
+			t.Fatalf("Ожидался статус 200, получен %d", code)
+		}
+		steps := resp["steps"].([]any)
+		if len(steps) != 2 {
+			t.Fatalf("Ожидалось 2 шага, получено %d", len(steps))
+		}
+		first, second := steps[0].(map[string]any), steps[1].(map[string]any)
+		if first["operation"] != "+" || first["output"] != 5.0 || first["agent_id"] != "agent-1" || first["started_at"] == nil {
+			t.Errorf("Неожиданный первый шаг: %v", first)
+		}
+		inputs := second["inputs"].([]any)
+		if second["operation"] != "*" || inputs[0] != 5.0 || inputs[1] != 4.0 || second["output"] != 20.0 {
+			t.Errorf("Неожиданный второй шаг: %v", second)
+		}
+		if deps := second["depends_on"].([]any); len(deps) != 1 || deps[0] != first["task_id"] {
+			t.Errorf("Второй шаг должен зависеть от первого: %v", second)
+		}
+	})
+
+	t.Run("Ошибка агента", func(t *testing.T) {
+		id := submit("1/0", func(task *models.Task) models.TaskResult {
+			return models.TaskResult{TaskID: task.ID, Error: "деление на ноль"}
+		})
+
+		_, resp := getTrace(id)
+		if resp["status"] != "error" || resp["error"] != "деление на ноль" {
+			t.Fatalf("Выражение должно завершиться ошибкой: %v", resp)
+		}
+		step := resp["steps"].([]any)[0].(map[string]any)
+		if step["error"] != "деление на ноль" || step["output"] != nil {
+			t.Errorf("Неожиданный шаг: %v", step)
+		}
+	})
+
+	t.Run("Несуществующее выражение", func(t *testing.T) {
+		if code, _ := getTrace("invalid"); code != http.StatusNotFound {
+			t.Errorf("Ожидался статус 404, получен %d", code)
+		}
+	})
+}
+
+func TestGetExpressionsHandler(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		status := "done"
+		if i%2 == 1 {
+			status = "error"
+		}
+		store.AddExpression(&models.Expression{ID: fmt.Sprint(i), Status: status, CreatedAt: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	list := func(query string) (*httptest.ResponseRecorder, []string, string) {
+		w := httptest.NewRecorder()
+		handler.GetExpressionsHandler(w, httptest.NewRequest("GET", "/api/v1/expressions?"+query, nil))
+
+		var resp struct {
+			Expressions []models.Expression `json:"expressions"`
+			NextCursor  string              `json:"next_cursor"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		var ids []string
+		for _, expr := range resp.Expressions {
+			ids = append(ids, expr.ID)
+		}
+		return w, ids, resp.NextCursor
+	}
+
+	t.Run("Страницы по курсору", func(t *testing.T) {
+		w, ids, cursor := list("status=done&sort=-created_at&limit=2")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Ожидался статус 200, получен %d", w.Code)
+		}
+		if w.Header().Get(api.TotalCountHeader) != "3" {
+			t.Errorf("Ожидалось 3 выражения всего, получено %s", w.Header().Get(api.TotalCountHeader))
+		}
+		if strings.Join(ids, ",") != "4,2" || cursor == "" {
+			t.Fatalf("Неожиданная первая страница: %v, курсор %q", ids, cursor)
+		}
+
+		_, ids, cursor = list("status=done&sort=-created_at&limit=2&cursor=" + cursor)
+		if strings.Join(ids, ",") != "0" || cursor != "" {
+			t.Errorf("Неожиданная последняя страница: %v, курсор %q", ids, cursor)
+		}
+	})
+
+	t.Run("Создано после", func(t *testing.T) {
+		_, ids, _ := list("created_after=" + base.Add(2*time.Second).Format(time.RFC3339))
+		if strings.Join(ids, ",") != "3,4" {
+			t.Errorf("Неожиданные выражения: %v", ids)
+		}
+	})
+
+	for _, query := range []string{"limit=0", "limit=abc", "sort=status", "status=unknown", "created_after=вчера", "cursor=???"} {
+		t.Run("Некорректный параметр "+query, func(t *testing.T) {
+			if w, _, _ := list(query); w.Code != http.StatusBadRequest {
+				t.Errorf("Ожидался статус 400, получен %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	submit := func(key, body string) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set(api.IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+		return w, created.ID
+	}
+
+	w, first := submit("order-42", `{"expression": "2+3"}`)
+	if w.Code != http.StatusCreated || first == "" {
+		t.Fatalf("Ожидался статус 201, получен %d", w.Code)
+	}
+
+	t.Run("Повтор запроса", func(t *testing.T) {
+		w, id := submit("order-42", `{ "expression":"2+3" }`)
+		if w.Code != http.StatusCreated || id != first {
+			t.Errorf("Ожидался ID %s, получен %s (статус %d)", first, id, w.Code)
+		}
+		if w.Header().Get(api.IdempotentReplayedHeader) != "true" {
+			t.Error("Повтор должен быть отмечен заголовком")
+		}
+		if expressions, _ := store.GetAllExpressions(); len(expressions) != 1 {
+			t.Errorf("Ожидалось одно выражение, создано %d", len(expressions))
+		}
+	})
+
+	t.Run("Тот же ключ с другим телом", func(t *testing.T) {
+		if w, _ := submit("order-42", `{"expression": "2+4"}`); w.Code != http.StatusConflict {
+			t.Errorf("Ожидался статус 409, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Тот же ключ у другого клиента", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewBufferString(`{"expression": "2+3"}`))
+		req.Header.Set(api.IdempotencyKeyHeader, "order-42")
+		req.Header.Set(api.TenantHeader, "web")
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, req)
+
+		var created struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(w.Body).Decode(&created)
+		if w.Code != http.StatusCreated || created.ID == first || w.Header().Get(api.IdempotentReplayedHeader) != "" {
+			t.Errorf("Другой клиент должен получить новое выражение, получен %s (статус %d)", created.ID, w.Code)
+		}
+	})
+
+	t.Run("Одновременные повторы", func(t *testing.T) {
+		// Каждый ответ указывает на одно и то же уже сохранённое выражение
+		ids := make(chan string, 10)
+		var wg sync.WaitGroup
+		for i := 0; i < cap(ids); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, id := submit("order-43", `{"expression": "3+4"}`)
+				if _, ok := store.GetExpression(id); !ok {
+					t.Errorf("Выражение %q не найдено", id)
+				}
+				ids <- id
+			}()
+		}
+		wg.Wait()
+		close(ids)
+
+		unique := make(map[string]bool)
+		for id := range ids {
+			unique[id] = true
+		}
+		if len(unique) != 1 {
+			t.Errorf("Ожидалось одно выражение, получено %d", len(unique))
+		}
+	})
+
+	t.Run("Без ключа", func(t *testing.T) {
+		if _, id := submit("", `{"expression": "2+3"}`); id == first {
+			t.Error("Запрос без ключа должен создавать новое выражение")
+		}
+	})
+
+	t.Run("Слишком длинный ключ", func(t *testing.T) {
+		if w, _ := submit(strings.Repeat("k", 256), `{"expression": "2+3"}`); w.Code != http.StatusBadRequest {
+			t.Errorf("Ожидался статус 400, получен %d", w.Code)
+		}
+	})
+}
+
+func TestExpressionEvents(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	hub := events.NewHub()
+	hub.Attach(store)
+	handler := api.NewHandler(store)
+	handler.SetEventHub(hub)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/expressions/", handler.ExpressionHandler)
+	mux.HandleFunc("/api/v1/events", handler.EventsHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// readEvents читает из потока названия событий, пока сервер его не закроет
+	readEvents := func(resp *http.Response) []string {
+		var names []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	t.Run("Поток одного выражения", func(t *testing.T) {
+		store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+		store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+
+		resp, err := http.Get(server.URL + "/api/v1/expressions/expr/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Ожидался text/event-stream, получен %s", ct)
+		}
+
+		store.CompleteTask("sum", 5)
+		names := readEvents(resp)
+		if strings.Join(names, ",") != "status,task,status,result" {
+			t.Errorf("Неожиданные события: %v", names)
+		}
+	})
+
+	t.Run("Завершённое выражение", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/api/v1/expressions/expr/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if names := readEvents(resp); strings.Join(names, ",") != "status,result" {
+			t.Errorf("Неожиданные события: %v", names)
+		}
+	})
+
+	t.Run("Несуществующее выражение", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/api/v1/expressions/invalid/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Ожидался статус 404, получен %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Общий поток", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/api/v1/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		store.AddExpression(&models.Expression{ID: "next", Status: "pending"})
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				if !strings.Contains(line, `"expression_id":"next"`) {
+					t.Errorf("Неожиданное событие: %s", line)
+				}
+				break
+			}
+		}
+	})
+}
+
+func TestWebSocketSession(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	hub := events.NewHub()
+	hub.Attach(store)
+	handler := api.NewHandler(store)
+	handler.SetEventHub(hub)
+	server := httptest.NewServer(http.HandlerFunc(handler.WebSocketHandler))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /api/v1/ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	if resp, err := http.ReadResponse(reader, nil); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Рукопожатие не удалось: %v", err)
+	}
+
+	// send отправляет текстовый кадр с нулевой маской, receive читает сообщение сервера
+	send := func(message string) {
+		frame := append([]byte{0x81, 0x80 | byte(len(message)), 0, 0, 0, 0}, message...)
+		conn.Write(frame)
+	}
+	receive := func() map[string]any {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatal(err)
+		}
+		length := int(header[1])
+		if length == 126 {
+			ext := make([]byte, 2)
+			io.ReadFull(reader, ext)
+			length = int(ext[0])<<8 | int(ext[1])
+		}
+		payload := make([]byte, length)
+		io.ReadFull(reader, payload)
+
+		var message map[string]any
+		json.Unmarshal(payload, &message)
+		return message
+	}
+
+	t.Run("Вычисление", func(t *testing.T) {
+		send(`{"type": "submit", "ref": "r1", "expression": "2+3"}`)
+		accepted := receive()
+		if accepted["type"] != "accepted" || accepted["ref"] != "r1" {
+			t.Fatalf("Ожидалось подтверждение, получено %v", accepted)
+		}
+
+		task, err := store.GetNextTask()
+		for deadline := time.Now().Add(2 * time.Second); err != nil && time.Now().Before(deadline); task, err = store.GetNextTask() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal("Задача не появилась в очереди")
+		}
+		store.CompleteTask(task.ID, evaluate(task))
+
+		var types []string
+		for len(types) == 0 || types[len(types)-1] != "result" {
+			message := receive()
+			types = append(types, message["type"].(string))
+			if message["type"] == "result" && message["expression"].(map[string]any)["result"] != 5.0 {
+				t.Errorf("Неожиданный результат: %v", message)
+			}
+		}
+		if !strings.HasPrefix(strings.Join(types, ","), "status,status") || !strings.Contains(strings.Join(types, ","), "task,status,result") {
+			t.Errorf("Неожиданные события: %v", types)
+		}
+	})
+
+	t.Run("Начальный статус", func(t *testing.T) {
+		send(`{"type": "submit", "ref": "r5", "expression": "2*3"}`)
+		if accepted := receive(); accepted["type"] != "accepted" {
+			t.Fatalf("Ожидалось подтверждение, получено %v", accepted)
+		}
+		if event := receive(); event["type"] != "status" || event["status"] != "pending" {
+			t.Errorf("Первым ожидалось событие pending, получено %v", event)
+		}
+	})
+
+	t.Run("Отмена", func(t *testing.T) {
+		send(`{"type": "submit", "ref": "r2", "expression": "7-1"}`)
+		accepted := receive()
+		for accepted["type"] != "accepted" {
+			accepted = receive()
+		}
+		send(`{"type": "cancel", "ref": "r2", "expression_id": "` + accepted["expression_id"].(string) + `"}`)
+		reply := receive()
+		for reply["type"] == "status" || reply["type"] == "task" {
+			reply = receive()
+		}
+		if reply["type"] != "cancelled" || reply["ref"] != "r2" {
+			t.Errorf("Ожидалось подтверждение отмены, получено %v", reply)
+		}
+
+		// Чужие выражения сеанс отменить не может
+		store.AddExpression(&models.Expression{ID: "foreign", Status: "processing"})
+		send(`{"type": "cancel", "ref": "r3", "expression_id": "foreign"}`)
+		reply = receive()
+		for reply["type"] == "status" || reply["type"] == "task" {
+			reply = receive()
+		}
+		if reply["type"] != "error" || reply["ref"] != "r3" {
+			t.Errorf("Ожидалась ошибка, получено %v", reply)
+		}
+		if expr, _ := store.GetExpression("foreign"); expr.Status != "processing" {
+			t.Errorf("Чужое выражение не должно отменяться, статус %s", expr.Status)
+		}
+	})
+
+	t.Run("Некорректные сообщения", func(t *testing.T) {
+		send(`{"type": "submit", "ref": "r4", "expression": ""}`)
+		if reply := receive(); reply["type"] != "error" || reply["ref"] != "r4" {
+			t.Errorf("Ожидалась ошибка, получено %v", reply)
+		}
+		send(`не json`)
+		if reply := receive(); reply["type"] != "error" {
+			t.Errorf("Ожидалась ошибка, получено %v", reply)
+		}
+	})
+}
+
+func TestLongPoll(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	// agent выполняет задачи из очереди, пока тест не закончится
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			if task, err := store.GetNextTask(); err == nil {
+				store.CompleteTask(task.ID, evaluate(task))
+			}
+		}
+	}()
+
+	t.Run("Ожидание результата", func(t *testing.T) {
+		store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+		store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+
+		w := httptest.NewRecorder()
+		handler.ExpressionHandler(w, httptest.NewRequest("GET", "/api/v1/expressions/expr?wait=5s", nil))
+
+		var expr models.Expression
+		json.NewDecoder(w.Body).Decode(&expr)
+		if w.Code != http.StatusOK || expr.Status != "done" || expr.Result != 5 {
+			t.Errorf("Ожидался готовый результат 5, получено %d %s %v", w.Code, expr.Status, expr.Result)
+		}
+	})
+
+	t.Run("Истёк срок ожидания", func(t *testing.T) {
+		store.AddExpression(&models.Expression{ID: "stuck", Status: "processing"})
+
+		start := time.Now()
+		w := httptest.NewRecorder()
+		handler.ExpressionHandler(w, httptest.NewRequest("GET", "/api/v1/expressions/stuck?wait=100ms", nil))
+		if w.Code != http.StatusOK || time.Since(start) < 100*time.Millisecond {
+			t.Errorf("Ожидался ответ 200 после 100ms, получен %d через %s", w.Code, time.Since(start))
+		}
+	})
+
+	t.Run("Некорректный wait", func(t *testing.T) {
+		for _, wait := range []string{"abc", "-1s", "10m"} {
+			w := httptest.NewRecorder()
+			handler.ExpressionHandler(w, httptest.NewRequest("GET", "/api/v1/expressions/expr?wait="+wait, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("wait=%s: ожидался статус 400, получен %d", wait, w.Code)
+			}
+		}
+	})
+
+	t.Run("Синхронное вычисление", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate?sync=true", bytes.NewBufferString(`{"expression": "(2+3)*4"}`)))
+
+		var expr models.Expression
+		json.NewDecoder(w.Body).Decode(&expr)
+		if w.Code != http.StatusOK || expr.Status != "done" || expr.Result != 20 {
+			t.Errorf("Ожидался готовый результат 20, получено %d %s %v", w.Code, expr.Status, expr.Result)
+		}
+	})
+
+	t.Run("Синхронное вычисление не успело", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.CalculateHandler(w, httptest.NewRequest("POST", "/api/v1/calculate?sync=true&wait=1ms", bytes.NewBufferString(`{"expression": "2+2"}`)))
+
+		var expr models.Expression
+		json.NewDecoder(w.Body).Decode(&expr)
+		if w.Code != http.StatusAccepted || expr.ID == "" {
+			t.Errorf("Ожидался статус 202 и ID выражения, получен %d", w.Code)
+		}
+	})
+}
+
+func TestBatchHandler(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	body := bytes.NewBufferString(`{"expressions": [
+		{"key": "a", "expression": "2+3"},
+		{"key": "b", "expression": ""},
+		{"key": "a", "expression": "4*5"},
+		{"expression": "(1+2)*4"}
+	]}`)
+	w := httptest.NewRecorder()
+	handler.BatchHandler(w, httptest.NewRequest("POST", "/api/v1/calculate/batch", body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Ожидался статус 201, получен %d", w.Code)
+	}
+
+	var batch models.Batch
+	json.NewDecoder(w.Body).Decode(&batch)
+	if len(batch.Items) != 4 {
+		t.Fatalf("Ожидалось 4 элемента, получено %d", len(batch.Items))
+	}
+	for i, accepted := range []bool{true, false, false, true} {
+		if item := batch.Items[i]; (item.ExpressionID != "") != accepted || (item.Error == "") != accepted {
+			t.Errorf("Неожиданный элемент %d: %+v", i, item)
+		}
+	}
+
+	getBatch := func() map[string]any {
+		w := httptest.NewRecorder()
+		handler.GetBatchHandler(w, httptest.NewRequest("GET", "/api/v1/batches/"+batch.ID, nil))
+		var resp map[string]any
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && getBatch()["finished"] != true {
+		if task, err := store.GetNextTask(); err == nil {
+			store.CompleteTask(task.ID, evaluate(task))
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	resp := getBatch()
+	progress := resp["progress"].(map[string]any)
+	if resp["finished"] != true || progress["done"] != 2.0 || resp["rejected"] != 2.0 || resp["total"] != 4.0 {
+		t.Fatalf("Неожиданный ход вычисления: %v", resp)
+	}
+	items := resp["items"].([]any)
+	if first := items[0].(map[string]any); first["key"] != "a" || first["result"] != 5.0 {
+		t.Errorf("Неожиданный первый элемент: %v", first)
+	}
+	if last := items[3].(map[string]any); last["result"] != 12.0 {
+		t.Errorf("Неожиданный последний элемент: %v", last)
+	}
+
+	t.Run("Пустой пакет", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.BatchHandler(w, httptest.NewRequest("POST", "/api/v1/calculate/batch", bytes.NewBufferString(`{"expressions": []}`)))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Ожидался статус 422, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Несуществующий пакет", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.GetBatchHandler(w, httptest.NewRequest("GET", "/api/v1/batches/invalid", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Ожидался статус 404, получен %d", w.Code)
+		}
+	})
+}
+
+func TestCancelExpression(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	request := func(method, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if strings.HasPrefix(path, "/internal/") {
+			handler.TaskHandler(w, req)
+		} else {
+			handler.ExpressionHandler(w, req)
+		}
+		return w
+	}
+
+	store.AddExpression(&models.Expression{ID: "running", Expression: "2*3", Status: "processing"})
+	store.AddTask(&models.Task{ID: "mul", ExpressionID: "running", Operation: "*", Arg1: 2, Arg2: 3, Status: "pending"})
+	store.AddExpression(&models.Expression{ID: "finished", Expression: "2", Status: "done", Result: 2})
+
+	t.Run("Отмена выражения", func(t *testing.T) {
+		// Задача уже выдана агенту
+		if w := request("GET", "/internal/task", ""); w.Code != http.StatusOK {
+			t.Fatalf("Ожидался статус 200, получен %d", w.Code)
+		}
+
+		w := request("POST", "/api/v1/expressions/running/cancel", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Ожидался статус 200, получен %d", w.Code)
+		}
+		var expr models.Expression
+		json.NewDecoder(w.Body).Decode(&expr)
+		if expr.Status != "cancelled" {
+			t.Errorf("Ожидался статус cancelled, получен %s", expr.Status)
+		}
+
+		if w := request("POST", "/internal/task", `{"task_id": "mul", "result": 6}`); w.Code != http.StatusConflict {
+			t.Errorf("Запоздавший результат: ожидался статус 409, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Результат задачи упавшего выражения", func(t *testing.T) {
+		store.AddExpression(&models.Expression{ID: "failing", Expression: "1/0+2*3", Status: "processing"})
+		store.AddTask(&models.Task{ID: "div", ExpressionID: "failing", Operation: "/", Arg1: 1, Arg2: 0, Status: "pending"})
+		store.AddTask(&models.Task{ID: "mul2", ExpressionID: "failing", Operation: "*", Arg1: 2, Arg2: 3, Status: "pending"})
+		request("GET", "/internal/task", "")
+		request("GET", "/internal/task", "")
+
+		if w := request("POST", "/internal/task", `{"task_id": "div", "error": "деление на ноль"}`); w.Code != http.StatusOK {
+			t.Fatalf("Ожидался статус 200, получен %d", w.Code)
+		}
+		if w := request("POST", "/internal/task", `{"task_id": "mul2", "result": 6}`); w.Code != http.StatusConflict {
+			t.Errorf("Результат отменённой задачи: ожидался статус 409, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Отмена вычисленного выражения", func(t *testing.T) {
+		if w := request("POST", "/api/v1/expressions/finished/cancel", ""); w.Code != http.StatusConflict {
+			t.Errorf("Ожидался статус 409, получен %d", w.Code)
+		}
+		if w := request("GET", "/api/v1/expressions/invalid/cancel", ""); w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Ожидался статус 405, получен %d", w.Code)
+		}
+		if w := request("POST", "/api/v1/expressions/invalid/cancel", ""); w.Code != http.StatusNotFound {
+			t.Errorf("Ожидался статус 404, получен %d", w.Code)
+		}
+	})
+
+	t.Run("Удаление выражения", func(t *testing.T) {
+		if w := request("DELETE", "/api/v1/expressions/running", ""); w.Code != http.StatusNoContent {
+			t.Fatalf("Ожидался статус 204, получен %d", w.Code)
+		}
+		if w := request("GET", "/api/v1/expressions/running", ""); w.Code != http.StatusNotFound {
+			t.Errorf("Ожидался статус 404, получен %d", w.Code)
+		}
+		if w := request("DELETE", "/api/v1/expressions/running", ""); w.Code != http.StatusNotFound {
+			t.Errorf("Ожидался статус 404, получен %d", w.Code)
+		}
+	})
+}
+
+func TestDerivativeHandler(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	handler := api.NewHandler(store)
+
+	tests := []struct {
+		name       string
+		body       string
+		status     int
+		derivative string
+	}{
+		{"Производная", `{"expression": "x*x+3*x", "variable": "x"}`, http.StatusOK, "2 * x + 3"},
+		{"Вычисление в точке", `{"expression": "x*x*y", "variable": "x", "at": {"x": 3, "y": 2}}`, http.StatusCreated, "2 * x * y"},
+		{"Некорректная переменная", `{"expression": "x*x", "variable": "2x"}`, http.StatusUnprocessableEntity, ""},
+		{"Некорректное выражение", `{"expression": "x*", "variable": "x"}`, http.StatusUnprocessableEntity, ""},
+		{"Некорректный JSON", `{"expression":`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/derivative", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.DerivativeHandler(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Ожидался статус %d, получен %d", tt.status, w.Code)
+			}
+			if tt.derivative == "" {
+				return
+			}
+			var resp struct {
+				Derivative string `json:"derivative"`
+				ID         string `json:"id"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Derivative != tt.derivative {
+				t.Errorf("Ожидалась производная %q, получена %q", tt.derivative, resp.Derivative)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) {
+				if task, err := store.GetNextTask(); err == nil {
+					store.CompleteTask(task.ID, evaluate(task))
+					continue
+				}
+				if expr, _ := store.GetExpression(resp.ID); expr.Status == "done" {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if expr, _ := store.GetExpression(resp.ID); expr.Status != "done" || expr.Result != 12 {
+				t.Errorf("Ожидался результат 12, получен %s %v", expr.Status, expr.Result)
+			}
+		})
+	}
+}
+
+func TestFormatHandler(t *testing.T) {
+	handler := api.NewHandler(storage.NewMemoryStorage())
+
+	tests := []struct {
+		name      string
+		body      string
+		status    int
+		canonical string
+	}{
+		{"Форматирование", `{"expression": "((1+2))*x/4"}`, http.StatusOK, "(1 + 2) * x / 4"},
+		{"Пустое выражение", `{"expression": ""}`, http.StatusUnprocessableEntity, ""},
+		{"Некорректное выражение", `{"expression": "(1+2"}`, http.StatusUnprocessableEntity, ""},
+		{"Некорректный JSON", `{"expression":`, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/format", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.FormatHandler(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Ожидался статус %d, получен %d", tt.status, w.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Canonical string `json:"canonical"`
+				LaTeX     string `json:"latex"`
+				MathML    string `json:"mathml"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Canonical != tt.canonical || resp.LaTeX == "" || !strings.HasPrefix(resp.MathML, "<math") {
+				t.Errorf("Неожиданный ответ: %+v", resp)
+			}
+		})
+	}
+}
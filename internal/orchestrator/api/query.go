@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"calc_service/internal/orchestrator/storage"
+)
+
+// TotalCountHeader — заголовок с числом выражений, подходящих под условия выборки
+const TotalCountHeader = "X-Total-Count"
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// parseExpressionQuery разбирает параметры выборки списка выражений:
+// status, created_after, created_before, sort, limit и cursor
+func parseExpressionQuery(values url.Values) (storage.ExpressionQuery, error) {
+	q := storage.ExpressionQuery{
+		Status: values.Get("status"),
+		Cursor: values.Get("cursor"),
+		Limit:  defaultPageSize,
+	}
+
+	switch q.Status {
+	case "", "pending", "processing", "done", "error", "cancelled":
+	default:
+		return q, fmt.Errorf("неизвестный статус %q", q.Status)
+	}
+
+	for name, bound := range map[string]*time.Time{
+		"created_after":  &q.CreatedAfter,
+		"created_before": &q.CreatedBefore,
+	} {
+		if raw := values.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339Nano, raw)
+			if err != nil {
+				return q, fmt.Errorf("%s: ожидается время в RFC 3339", name)
+			}
+			*bound = t
+		}
+	}
+
+	switch sort := values.Get("sort"); sort {
+	case "", "created_at":
+	case "-created_at":
+		q.Descending = true
+	default:
+		return q, fmt.Errorf("сортировка %q не поддерживается", sort)
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return q, fmt.Errorf("limit: ожидается число от 1 до %d", maxPageSize)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"calc_service/internal/orchestrator/events"
+	"calc_service/internal/orchestrator/websocket"
+	"calc_service/pkg/errors"
+
+	"github.com/google/uuid"
+)
+
+// Сообщения клиента в сеансе WebSocket
+const (
+	sessionSubmit = "submit" // Отправить выражение; поля те же, что у /api/v1/calculate
+	sessionCancel = "cancel" // Отменить выражение expression_id, отправленное в этом сеансе
+)
+
+// sessionRequest — сообщение клиента. Ref клиент выбирает сам, и он возвращается в ответе
+type sessionRequest struct {
+	Type         string `json:"type"`
+	Ref          string `json:"ref"`
+	ExpressionID string `json:"expression_id"`
+	calculateRequest
+}
+
+// sessionReply — ответ на сообщение клиента: accepted, cancelled или error.
+// Ход вычисления приходит событиями status, task и result в формате потока событий
+type sessionReply struct {
+	Type         string `json:"type"`
+	Ref          string `json:"ref,omitempty"`
+	ExpressionID string `json:"expression_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Обработчик сеанса WebSocket: клиент отправляет и отменяет выражения и получает
+// ход их вычисления по одному соединению
+func (h *Handler) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		http.Error(w, "Поток событий не настроен", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	s := &session{
+		handler:   h,
+		conn:      conn,
+		tenant:    r.Header.Get(TenantHeader),
+		submitted: make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	s.run()
+}
+
+type session struct {
+	handler   *Handler
+	conn      *websocket.Conn
+	tenant    string          // Клиент из заголовка рукопожатия
+	submitted map[string]bool // Выражения, отправленные в сеансе: отменить можно только их
+	done      chan struct{}   // Закрывается, когда клиент отключился
+	wg        sync.WaitGroup
+}
+
+func (s *session) run() {
+	defer func() {
+		close(s.done)
+		s.wg.Wait()
+		s.conn.Close(websocket.CloseNormal, "")
+	}()
+
+	for {
+		data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req sessionRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.send(sessionReply{Type: "error", Error: "Некорректный JSON"})
+			continue
+		}
+		switch req.Type {
+		case sessionSubmit:
+			s.submit(&req)
+		case sessionCancel:
+			s.cancel(&req)
+		default:
+			s.send(sessionReply{Type: "error", Ref: req.Ref, Error: "неизвестный тип сообщения " + req.Type})
+		}
+	}
+}
+
+func (s *session) submit(req *sessionRequest) {
+	req.Tenant = s.tenant
+
+	// Подписываемся до сохранения выражения, чтобы не пропустить ни одного события,
+	// включая начальный статус pending
+	id := uuid.New().String()
+	sub := s.handler.events.Subscribe(id)
+	expr, err := s.handler.newExpression(id, &req.calculateRequest)
+	if err != nil {
+		s.handler.events.Unsubscribe(sub)
+		s.send(sessionReply{Type: "error", Ref: req.Ref, Error: err.Error()})
+		return
+	}
+
+	s.submitted[expr.ID] = true
+	s.send(sessionReply{Type: "accepted", Ref: req.Ref, ExpressionID: expr.ID})
+	s.wg.Add(1)
+	go s.forward(expr.ID, sub)
+	go s.handler.processExpression(expr, req.Expression, req.options())
+}
+
+// forward пересылает клиенту события выражения, пока оно не завершится
+func (s *session) forward(exprID string, sub *events.Subscription) {
+	defer s.wg.Done()
+	defer s.handler.events.Unsubscribe(sub)
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				s.send(sessionReply{Type: "error", ExpressionID: exprID, Error: "клиент не успевал читать события, часть пропущена"})
+				return
+			}
+			s.send(event)
+			if event.Type == events.TypeResult || event.Status == "cancelled" {
+				return
+			}
+		}
+	}
+}
+
+func (s *session) cancel(req *sessionRequest) {
+	// Выражения других сеансов и клиентов для сеанса не существуют
+	if !s.submitted[req.ExpressionID] {
+		s.send(sessionReply{Type: "error", Ref: req.Ref, ExpressionID: req.ExpressionID, Error: errors.ErrExpressionNotFound.Error()})
+		return
+	}
+	if err := s.handler.storage.CancelExpression(req.ExpressionID); err != nil {
+		s.send(sessionReply{Type: "error", Ref: req.Ref, ExpressionID: req.ExpressionID, Error: err.Error()})
+		return
+	}
+	s.send(sessionReply{Type: "cancelled", Ref: req.Ref, ExpressionID: req.ExpressionID})
+}
+
+func (s *session) send(message interface{}) {
+	data, _ := json.Marshal(message)
+	s.conn.WriteMessage(data)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"calc_service/pkg/models"
+)
+
+// trace — ход вычисления выражения по выполненным задачам
+type trace struct {
+	ID         string      `json:"id"`
+	Expression string      `json:"expression"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Steps      []traceStep `json:"steps"`
+	WallTimeMs int64       `json:"wall_time_ms"` // От выдачи первой задачи до результата последней
+	WorkMs     int64       `json:"work_ms"`      // Суммарное время задач у агентов
+}
+
+// traceStep — одна выполненная задача
+type traceStep struct {
+	Step       int        `json:"step"`
+	TaskID     string     `json:"task_id"`
+	Operation  string     `json:"operation"`
+	Inputs     []float64  `json:"inputs"`
+	DependsOn  []string   `json:"depends_on,omitempty"` // Задачи, результаты которых стали входами
+	Output     *float64   `json:"output,omitempty"`
+	Outputs    []float64  `json:"outputs,omitempty"` // Результат-массив (matmul, inv)
+	Error      string     `json:"error,omitempty"`
+	AgentID    string     `json:"agent_id,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at"`
+	DurationMs int64      `json:"duration_ms"`
+	Retries    int        `json:"retries"`
+}
+
+// Обработчик трассировки: выполненные задачи выражения в порядке получения результатов.
+// Для выражения, которое ещё вычисляется, возвращаются уже выполненные шаги
+func (h *Handler) GetExpressionTraceHandler(w http.ResponseWriter, r *http.Request) {
+	expr, exists := h.storage.GetExpression(expressionID(r))
+	if !exists {
+		http.Error(w, "Выражение не найдено", http.StatusNotFound)
+		return
+	}
+
+	tasks, err := h.storage.GetExpressionTasks(expr.ID)
+	if err != nil {
+		http.Error(w, "Ошибка получения данных", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildTrace(expr, tasks))
+}
+
+func buildTrace(expr *models.Expression, tasks []*models.Task) trace {
+	executed := make([]*models.Task, 0, len(tasks))
+	for _, task := range tasks {
+		if task.FinishedAt != nil {
+			executed = append(executed, task)
+		}
+	}
+	sort.SliceStable(executed, func(i, j int) bool {
+		return executed[i].FinishedAt.Before(*executed[j].FinishedAt)
+	})
+
+	result := trace{
+		ID:         expr.ID,
+		Expression: expr.Expression,
+		Status:     expr.Status,
+		Error:      expr.Error,
+		Steps:      make([]traceStep, 0, len(executed)),
+	}
+	var first time.Time
+	for i, task := range executed {
+		step := traceStep{
+			Step:       i + 1,
+			TaskID:     task.ID,
+			Operation:  task.Operation,
+			Inputs:     inputs(task),
+			DependsOn:  task.UniqueDependencies(),
+			Error:      task.Error,
+			AgentID:    task.AgentID,
+			StartedAt:  task.StartedAt,
+			FinishedAt: task.FinishedAt,
+			Retries:    task.Retries,
+		}
+		if task.Status == "done" {
+			if task.Results != nil {
+				step.Outputs = task.Results
+			} else {
+				value := task.Result
+				step.Output = &value
+			}
+		}
+		if task.StartedAt != nil {
+			step.DurationMs = task.FinishedAt.Sub(*task.StartedAt).Milliseconds()
+			if first.IsZero() || task.StartedAt.Before(first) {
+				first = *task.StartedAt
+			}
+		}
+		result.WorkMs += step.DurationMs
+		result.Steps = append(result.Steps, step)
+	}
+	if n := len(executed); n > 0 && !first.IsZero() {
+		result.WallTimeMs = executed[n-1].FinishedAt.Sub(first).Milliseconds()
+	}
+	return result
+}
+
+// inputs возвращает операнды, с которыми задача была выполнена
+func inputs(task *models.Task) []float64 {
+	switch {
+	case task.Interval || models.IsListOperation(task.Operation):
+		return task.Args
+	case task.Operation == "sqrt" || task.Operation == "abs" || task.Operation == "weekday":
+		return []float64{task.Arg1}
+	}
+	return []float64{task.Arg1, task.Arg2}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"calc_service/pkg/models"
+)
+
+const (
+	maxWait         = 60 * time.Second // Наибольшее значение параметра wait
+	defaultSyncWait = 30 * time.Second // Сколько ждать результата в POST /calculate?sync=true без wait
+)
+
+// parseWait разбирает параметр wait — длительность вида 30s или 1500ms
+func parseWait(values url.Values) (time.Duration, error) {
+	raw := values.Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 || wait > maxWait {
+		return 0, fmt.Errorf("wait: ожидается длительность от 0 до %s, например 30s", maxWait)
+	}
+	return wait, nil
+}
+
+// awaitExpression ждёт, пока выражение не завершится, но не дольше wait и не дольше,
+// чем клиент готов ждать ответа. Возвращает выражение и признак того, что оно завершено
+func (h *Handler) awaitExpression(ctx context.Context, id string, wait time.Duration) (*models.Expression, bool) {
+	done, err := h.storage.ExpressionDone(id)
+	if err != nil {
+		return nil, false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	finished := false
+	select {
+	case <-done:
+		finished = true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	expr, exists := h.storage.GetExpression(id)
+	if !exists {
+		return nil, false
+	}
+	return expr, finished
+}
@@ -0,0 +1,138 @@
+// Package events раздаёт подписчикам изменения выражений и задач, о которых сообщает хранилище
+package events
+
+import (
+	"sync"
+	"time"
+
+	"calc_service/internal/orchestrator/storage"
+	"calc_service/pkg/models"
+)
+
+// Виды событий
+const (
+	TypeStatus = "status" // Выражение добавлено или сменило статус
+	TypeTask   = "task"   // Задача выполнена или завершилась ошибкой
+	TypeResult = "result" // Выражение завершено: done или error
+)
+
+// bufferSize — сколько событий подписчик может не забирать, прежде чем подписка закроется
+const bufferSize = 256
+
+// Event — изменение выражения. Для TypeStatus и TypeResult заполнено Expression, для TypeTask — Task
+type Event struct {
+	ID           uint64             `json:"id"` // Порядковый номер события, общий для всех подписчиков
+	Type         string             `json:"type"`
+	ExpressionID string             `json:"expression_id"`
+	Status       string             `json:"status,omitempty"`
+	Expression   *models.Expression `json:"expression,omitempty"`
+	Task         *TaskEvent         `json:"task,omitempty"`
+	Time         time.Time          `json:"time"`
+}
+
+// TaskEvent — итог выполнения задачи
+type TaskEvent struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Status    string    `json:"status"`
+	Result    float64   `json:"result"`
+	Results   []float64 `json:"results,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Hub рассылает события подписчикам. Публикация не блокируется: подписчик,
+// который не успевает забирать события, отключается
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	lastID      uint64
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscription — подписка на события одного выражения или всех выражений
+type Subscription struct {
+	C            <-chan Event // Закрывается при отписке или переполнении
+	events       chan Event
+	expressionID string
+}
+
+// Subscribe подписывает на события выражения expressionID; пустой ID — на события всех выражений
+func (h *Hub) Subscribe(expressionID string) *Subscription {
+	events := make(chan Event, bufferSize)
+	sub := &Subscription{C: events, events: events, expressionID: expressionID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe отменяет подписку и закрывает её канал
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Publish присваивает событию номер и рассылает его подписчикам
+func (h *Hub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	event.ID = h.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for sub := range h.subscribers {
+		if sub.expressionID != "" && sub.expressionID != event.ExpressionID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+}
+
+// ExpressionChanged публикует смену статуса, а для завершённого выражения — и результат.
+// Подходит для MemoryStorage.OnExpressionChanged
+func (h *Hub) ExpressionChanged(expr models.Expression) {
+	h.Publish(Event{Type: TypeStatus, ExpressionID: expr.ID, Status: expr.Status, Expression: &expr})
+	if expr.Status == "done" || expr.Status == "error" {
+		h.Publish(Event{Type: TypeResult, ExpressionID: expr.ID, Status: expr.Status, Expression: &expr})
+	}
+}
+
+// TaskFinished публикует итог задачи. Подходит для MemoryStorage.OnTaskFinished
+func (h *Hub) TaskFinished(task models.Task) {
+	h.Publish(Event{
+		Type:         TypeTask,
+		ExpressionID: task.ExpressionID,
+		Task: &TaskEvent{
+			ID:        task.ID,
+			Operation: task.Operation,
+			Status:    task.Status,
+			Result:    task.Result,
+			Results:   task.Results,
+			Error:     task.Error,
+		},
+	})
+}
+
+// Attach подписывает хаб на изменения хранилища
+func (h *Hub) Attach(store *storage.MemoryStorage) {
+	store.OnExpressionChanged(h.ExpressionChanged)
+	store.OnTaskFinished(h.TaskFinished)
+}
@@ -0,0 +1,72 @@
+package events_test
+
+import (
+	"testing"
+
+	"calc_service/internal/orchestrator/events"
+	"calc_service/internal/orchestrator/storage"
+	"calc_service/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// drain забирает из подписки все уже опубликованные события
+func drain(sub *events.Subscription) []events.Event {
+	var received []events.Event
+	for {
+		select {
+		case event := <-sub.C:
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func TestHubFromStorage(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	hub := events.NewHub()
+	hub.Attach(store)
+
+	all := hub.Subscribe("")
+	one := hub.Subscribe("expr")
+	defer hub.Unsubscribe(all)
+	defer hub.Unsubscribe(one)
+
+	store.AddExpression(&models.Expression{ID: "other", Status: "pending"})
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+	store.CompleteTask("sum", 5)
+
+	received := drain(one)
+	var types []string
+	for _, event := range received {
+		types = append(types, event.Type+":"+event.Status)
+	}
+	assert.Equal(t, []string{"status:processing", "task:", "status:done", "result:done"}, types)
+	assert.Equal(t, "sum", received[1].Task.ID)
+	assert.Equal(t, 5.0, received[3].Expression.Result)
+
+	// Общая подписка получает события всех выражений, номера растут
+	everything := drain(all)
+	assert.Len(t, everything, 5)
+	for i := 1; i < len(everything); i++ {
+		assert.Greater(t, everything[i].ID, everything[i-1].ID)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	hub := events.NewHub()
+	sub := hub.Subscribe("")
+
+	// Подписчик, который не забирает события, отключается, а публикация не блокируется
+	for i := 0; i < 1000; i++ {
+		hub.Publish(events.Event{Type: events.TypeStatus, ExpressionID: "expr"})
+	}
+	received := 0
+	for range sub.C {
+		received++
+	}
+	assert.Less(t, received, 1000)
+	hub.Unsubscribe(sub)
+}
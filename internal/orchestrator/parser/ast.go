@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"calc_service/pkg/errors"
+)
+
+// Типы узлов синтаксического дерева
+const (
+	NodeNumber   = "number"   // Числовой литерал
+	NodeVariable = "variable" // Имя параметра функции
+	NodeOperator = "operator" // Бинарный оператор: +, -, *, /
+	NodeCall     = "call"     // Вызов функции
+	NodeList     = "list"     // Список [a, b, c] или матрица — список строк [[a, b], [c, d]]
+	NodeElement  = "element"  // Элемент результата-массива единственного аргумента
+)
+
+// Node — узел синтаксического дерева выражения
+type Node struct {
+	Type  string  `json:"type"`            // Тип узла
+	Value float64 `json:"value,omitempty"` // Значение числового литерала
+	Name  string  `json:"name,omitempty"`  // Оператор, имя функции или переменной
+	Args  []*Node `json:"args,omitempty"`  // Операнды оператора или аргументы вызова
+	Index int     `json:"index,omitempty"` // Номер элемента для NodeElement
+	Shape []int   `json:"shape,omitempty"` // Размеры операндов-массивов вызова (matmul, det, inv)
+	Unit  string  `json:"unit,omitempty"`  // Единица числового литерала в записи: 5 km
+	// Interval — операция над интервалами: аргументы — пары границ операндов
+	Interval bool `json:"interval,omitempty"`
+}
+
+// MarshalJSON выводит значение у числовых литералов, даже если оно равно нулю
+func (n *Node) MarshalJSON() ([]byte, error) {
+	if n.Type == NodeNumber {
+		return json.Marshal(struct {
+			Type  string  `json:"type"`
+			Value float64 `json:"value"`
+			Unit  string  `json:"unit,omitempty"`
+		}{n.Type, n.Value, n.Unit})
+	}
+	type node Node // Без метода MarshalJSON, чтобы не зациклиться
+	return json.Marshal((*node)(n))
+}
+
+// buildTree строит синтаксическое дерево из обратной польской записи
+func buildTree(rpn []string) (*Node, error) {
+	var stack []*Node
+
+	for _, token := range rpn {
+		var argc int
+		node := &Node{Name: token}
+
+		switch {
+		case isNumber(token):
+			node = &Node{Type: NodeNumber, Value: parseNumber(token)}
+		case datePattern.MatchString(token):
+			value, err := parseDate(token)
+			if err != nil {
+				return nil, err
+			}
+			node = &Node{Type: NodeDate, Name: token, Value: value}
+		case isQuantity(token):
+			value, unit, _ := strings.Cut(token, " ")
+			node = &Node{Type: NodeNumber, Value: parseNumber(value), Unit: unit}
+		case isListToken(token):
+			node = &Node{Type: NodeList}
+			argc, _ = strconv.Atoi(token[1 : len(token)-1])
+		case isCallToken(token):
+			node.Type = NodeCall
+			node.Name, argc = parseCallToken(token)
+		case precedence(token) > 0:
+			node.Type = NodeOperator
+			argc = 2
+		default:
+			node.Type = NodeVariable
+		}
+
+		if len(stack) < argc {
+			return nil, errors.ErrInvalidExpression
+		}
+		if argc > 0 {
+			node.Args = append([]*Node(nil), stack[len(stack)-argc:]...)
+			stack = stack[:len(stack)-argc]
+		}
+		stack = append(stack, node)
+	}
+
+	if len(stack) != 1 {
+		return nil, errors.ErrInvalidExpression
+	}
+
+	return stack[0], nil
+}
+
+// callToken кодирует вызов функции в RPN как имя с числом аргументов: hyp(2)
+func callToken(name string, argc int) string {
+	return fmt.Sprintf("%s(%d)", name, argc)
+}
+
+// listToken кодирует список в RPN как число его элементов в квадратных скобках: [3]
+func listToken(count int) string {
+	return fmt.Sprintf("[%d]", count)
+}
+
+func isListToken(token string) bool {
+	return strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]")
+}
+
+func isCallToken(token string) bool {
+	return strings.HasSuffix(token, ")") && strings.Contains(token, "(")
+}
+
+func parseCallToken(token string) (string, int) {
+	open := strings.Index(token, "(")
+	argc, _ := strconv.Atoi(token[open+1 : len(token)-1])
+	return token[:open], argc
+}
@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"calc_service/pkg/errors"
+	"calc_service/pkg/models"
+)
+
+// NodeDate — литерал даты 2026-10-18 или даты со временем 2026-10-18T09:30 (UTC).
+// Name хранит запись, Value — секунды от начала эпохи Unix
+const NodeDate = "date"
+
+// Запись даты: день, необязательное время и Z
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}(:\d{2})?Z?)?`)
+
+// Допустимые форматы записи даты
+var dateLayouts = []string{"2006-01-02T15:04:05Z", "2006-01-02T15:04:05", "2006-01-02T15:04Z", "2006-01-02T15:04", "2006-01-02"}
+
+// readDate считывает дату, если она начинается с позиции i
+func readDate(expr string, i *int) (string, bool) {
+	date := datePattern.FindString(expr[*i:])
+	if date == "" {
+		return "", false
+	}
+	*i += len(date) - 1 // Индекс на последнем символе даты
+	return date, true
+}
+
+// parseDate переводит запись даты в секунды от начала эпохи
+func parseDate(s string) (float64, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return float64(t.Unix()), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: дата %s", errors.ErrInvalidExpression, s)
+}
+
+// instant — признак момента времени в размерности: дата измеряется в секундах от
+// начала эпохи, но складывать её можно только с длительностью
+const instant = 8
+
+var dateDimension = func() dimension {
+	var d dimension
+	d[instant] = 1
+	return d
+}()
+
+// Размерность длительности — секунды
+var durationDimension = base(2)
+
+// dateDimensionOf вычисляет размерность операции, в которой участвует дата.
+// ok = false — дат среди операндов нет, и действуют обычные правила
+func dateDimensionOf(n *Node, dims []dimension) (d dimension, ok bool, err error) {
+	dates := 0
+	for _, d := range dims {
+		if d[instant] != 0 {
+			dates++
+		}
+	}
+	if n.Name == "weekday" {
+		if dims[0] != dateDimension {
+			return dimension{}, true, fmt.Errorf("%w: weekday ожидает дату, получено %s", errors.ErrDimensionMismatch, dims[0].describe())
+		}
+		return dimension{}, true, nil
+	}
+	if dates == 0 {
+		return dimension{}, false, nil
+	}
+
+	mismatch := func() (dimension, bool, error) {
+		return dimension{}, true, fmt.Errorf("%w: %s: %s и %s", errors.ErrDimensionMismatch, n.Name, dims[0].describe(), dims[len(dims)-1].describe())
+	}
+	switch {
+	case n.Type == NodeOperator && n.Name == "+":
+		// Дата плюс длительность в любом порядке
+		if dates == 1 && (dims[0] == durationDimension || dims[1] == durationDimension) {
+			return dateDimension, true, nil
+		}
+		return mismatch()
+	case n.Type == NodeOperator && n.Name == "-":
+		switch {
+		case dims[0] == dateDimension && dims[1] == dateDimension:
+			return durationDimension, true, nil
+		case dims[0] == dateDimension && dims[1] == durationDimension:
+			return dateDimension, true, nil
+		}
+		return mismatch()
+	case n.Type == NodeList || n.Name == "avg" || n.Name == "median" || n.Name == "transpose":
+		for _, d := range dims {
+			if d != dateDimension {
+				return mismatch()
+			}
+		}
+		return dateDimension, true, nil
+	case n.Name == "count":
+		return dimension{}, true, nil
+	}
+	return dimension{}, true, fmt.Errorf("%w: операция %s над датой", errors.ErrDimensionMismatch, n.Name)
+}
+
+// resultType определяет, в каком виде выдавать результат выражения: дата,
+// длительность в секундах или обычное число
+func resultType(dim dimension, unit string) string {
+	switch {
+	case dim == dateDimension:
+		return models.ResultDate
+	case dim == durationDimension && unit == "s":
+		return models.ResultDuration
+	}
+	return ""
+}
+
+// groupQuantities записывает подряд идущие величины суммой в скобках:
+// 3d 4h означает (3 d + 4 h), 5 ft 3 in — (5 ft + 3 in)
+func groupQuantities(tokens []string) []string {
+	var res []string
+	for i := 0; i < len(tokens); {
+		j := i
+		for j < len(tokens) && isQuantity(tokens[j]) {
+			j++
+		}
+		if j-i < 2 {
+			res = append(res, tokens[i])
+			i++
+			continue
+		}
+		res = append(res, "(")
+		for k := i; k < j; k++ {
+			if k > i {
+				res = append(res, "+")
+			}
+			res = append(res, tokens[k])
+		}
+		res = append(res, ")")
+		i = j
+	}
+	return res
+}
@@ -0,0 +1,296 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"calc_service/pkg/errors"
+)
+
+// Derivative строит упрощённую символьную производную выражения по переменной.
+// Вызовы пользовательских функций раскрываются до дифференцирования.
+func Derivative(expr, variable string, opts Options) (*Node, error) {
+	_, ast, err := Syntax(expr)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := expandCalls(ast, opts.Functions, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := derive(tree, variable)
+	if err != nil {
+		return nil, err
+	}
+	return tidy(d), nil
+}
+
+// derive применяет правила дифференцирования к узлу
+func derive(n *Node, x string) (*Node, error) {
+	switch n.Type {
+	case NodeNumber:
+		return number(0), nil
+	case NodeVariable:
+		if n.Name == x {
+			return number(1), nil
+		}
+		return number(0), nil
+	case NodeOperator:
+		u, v := n.Args[0], n.Args[1]
+		du, err := derive(u, x)
+		if err != nil {
+			return nil, err
+		}
+		dv, err := derive(v, x)
+		if err != nil {
+			return nil, err
+		}
+
+		switch n.Name {
+		case "+", "-":
+			return operator(n.Name, du, dv), nil
+		case "*":
+			return operator("+", operator("*", du, v), operator("*", u, dv)), nil
+		case "/":
+			if !dependsOn(v, x) {
+				return operator("/", du, v), nil
+			}
+			numerator := operator("-", operator("*", du, v), operator("*", u, dv))
+			return operator("/", numerator, operator("*", v, v)), nil
+		}
+	case NodeCall:
+		if len(n.Args) != 1 {
+			break
+		}
+		u := n.Args[0]
+		du, err := derive(u, x)
+		if err != nil {
+			return nil, err
+		}
+
+		switch n.Name {
+		case "sqrt":
+			return operator("/", du, operator("*", number(2), n)), nil
+		case "abs":
+			return operator("/", operator("*", du, u), n), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: производная %s", errors.ErrInvalidOperation, n)
+}
+
+// tidy упрощает символьное выражение: вычисляет операции над литералами, убирает
+// нули и единицы, выносит минус и приводит подобные слагаемые.
+// В отличие от optimize, переменные считаются конечными числами, поэтому 0*x = 0.
+func tidy(n *Node) *Node {
+	if n.Type != NodeOperator && n.Type != NodeCall {
+		return n
+	}
+	args := make([]*Node, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = tidy(arg)
+	}
+	res := &Node{Type: n.Type, Name: n.Name, Args: args}
+	if value, ok := fold(res); ok {
+		return number(value)
+	}
+	if n.Type != NodeOperator {
+		return res
+	}
+
+	a, b := args[0], args[1]
+	switch n.Name {
+	case "+", "-":
+		if isLiteral(b, 0) {
+			return a
+		}
+		return collectLikeTerms(res)
+	case "*":
+		if isLiteral(a, 0) || isLiteral(b, 0) {
+			return number(0)
+		}
+		if isLiteral(a, 1) {
+			return b
+		}
+		if isLiteral(b, 1) {
+			return a
+		}
+		if inner, ok := negated(a); ok {
+			return negate(tidy(operator("*", inner, b)))
+		}
+		if inner, ok := negated(b); ok {
+			return negate(tidy(operator("*", a, inner)))
+		}
+		// Числовой множитель пишется первым, соседние множители перемножаются
+		if b.Type == NodeNumber {
+			a, b = b, a
+		}
+		if a.Type == NodeNumber && b.Type == NodeOperator && b.Name == "*" && b.Args[0].Type == NodeNumber {
+			return tidy(operator("*", number(a.Value*b.Args[0].Value), b.Args[1]))
+		}
+		return operator("*", a, b)
+	case "/":
+		if isLiteral(a, 0) {
+			return number(0)
+		}
+		if isLiteral(b, 1) {
+			return a
+		}
+		if inner, ok := negated(a); ok {
+			return negate(tidy(operator("/", inner, b)))
+		}
+		if inner, ok := negated(b); ok {
+			return negate(tidy(operator("/", a, inner)))
+		}
+	}
+	return res
+}
+
+// collectLikeTerms раскрывает цепочку сложений и вычитаний в слагаемые c*u,
+// складывает коэффициенты подобных слагаемых и числа и собирает цепочку заново
+// в порядке первого появления слагаемых
+func collectLikeTerms(n *Node) *Node {
+	type term struct {
+		coef float64
+		rest *Node // nil для числа
+	}
+	var terms []*term
+	index := make(map[string]*term)
+
+	var walk func(n *Node, sign float64)
+	walk = func(n *Node, sign float64) {
+		if n.Type == NodeOperator && (n.Name == "+" || n.Name == "-") {
+			walk(n.Args[0], sign)
+			if n.Name == "-" {
+				sign = -sign
+			}
+			walk(n.Args[1], sign)
+			return
+		}
+		c, rest := coefficient(n)
+		key := ""
+		if rest != nil {
+			key = termKey(rest)
+		}
+		if t, ok := index[key]; ok {
+			t.coef += sign * c
+			return
+		}
+		t := &term{coef: sign * c, rest: rest}
+		index[key] = t
+		terms = append(terms, t)
+	}
+	walk(n, 1)
+
+	var res *Node
+	for _, t := range terms {
+		if t.coef == 0 {
+			continue
+		}
+		magnitude := number(math.Abs(t.coef))
+		if t.rest != nil {
+			magnitude = t.rest
+			if math.Abs(t.coef) != 1 {
+				magnitude = operator("*", number(math.Abs(t.coef)), t.rest)
+			}
+		}
+		switch {
+		case res == nil && t.coef > 0:
+			res = magnitude
+		case res == nil:
+			res = negate(magnitude)
+		case t.coef > 0:
+			res = operator("+", res, magnitude)
+		default:
+			res = operator("-", res, magnitude)
+		}
+	}
+	if res == nil {
+		return number(0)
+	}
+	return res
+}
+
+// coefficient разделяет слагаемое на произведение числовых множителей и произведение
+// остальных в прежнем порядке: 2 * x * x — это 2 и x * x
+func coefficient(n *Node) (float64, *Node) {
+	if inner, ok := negated(n); ok {
+		c, rest := coefficient(inner)
+		return -c, rest
+	}
+	c := 1.0
+	var rest *Node
+	for _, f := range factors(n) {
+		switch {
+		case f.Type == NodeNumber:
+			c *= f.Value
+		case rest == nil:
+			rest = f
+		default:
+			rest = operator("*", rest, f)
+		}
+	}
+	return c, rest
+}
+
+// factors раскрывает цепочку умножений в список множителей
+func factors(n *Node) []*Node {
+	if n.Type == NodeOperator && n.Name == "*" {
+		return append(factors(n.Args[0]), factors(n.Args[1])...)
+	}
+	return []*Node{n}
+}
+
+// termKey — запись произведения, не зависящая от порядка множителей: x * y и y * x подобны
+func termKey(n *Node) string {
+	var keys []string
+	for _, f := range factors(n) {
+		keys = append(keys, f.String())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " * ")
+}
+
+// negated возвращает u для -u: отрицательного литерала или разности 0 - u
+func negated(n *Node) (*Node, bool) {
+	if n.Type == NodeNumber && n.Value < 0 {
+		return number(-n.Value), true
+	}
+	if n.Type == NodeOperator && n.Name == "-" && isLiteral(n.Args[0], 0) {
+		return n.Args[1], true
+	}
+	return nil, false
+}
+
+func negate(n *Node) *Node {
+	if n.Type == NodeNumber {
+		return number(-n.Value)
+	}
+	if inner, ok := negated(n); ok {
+		return inner
+	}
+	return operator("-", number(0), n)
+}
+
+// dependsOn сообщает, входит ли переменная в поддерево
+func dependsOn(n *Node, x string) bool {
+	if n.Type == NodeVariable {
+		return n.Name == x
+	}
+	for _, arg := range n.Args {
+		if dependsOn(arg, x) {
+			return true
+		}
+	}
+	return false
+}
+
+func number(value float64) *Node {
+	return &Node{Type: NodeNumber, Value: value}
+}
+
+func operator(op string, a, b *Node) *Node {
+	return &Node{Type: NodeOperator, Name: op, Args: []*Node{a, b}}
+}
@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"sort"
+
+	"calc_service/pkg/models"
+)
+
+// Graph — граф задач выражения с оценкой времени выполнения при неограниченном числе агентов
+type Graph struct {
+	Tasks          []GraphTask `json:"tasks"`            // Задачи в порядке выполнения зависимостей
+	CriticalPath   []string    `json:"critical_path"`    // Цепочка задач, определяющая время выполнения
+	CriticalPathMs int         `json:"critical_path_ms"` // Оценка времени вычисления выражения
+	TotalWorkMs    int         `json:"total_work_ms"`    // Суммарное время всех задач на одном агенте
+	MaxParallelism int         `json:"max_parallelism"`  // Наибольшее число одновременно выполняемых задач
+}
+
+// GraphTask — задача графа с её зависимостями и самым ранним временем выполнения
+type GraphTask struct {
+	*models.Task
+	DependsOn []string `json:"depends_on"` // Задачи, результаты которых нужны этой
+	StartMs   int      `json:"start_ms"`   // Самое раннее начало от запуска выражения
+	FinishMs  int      `json:"finish_ms"`  // Самое раннее окончание
+}
+
+// BuildGraph строит граф по задачам выражения; каждая задача начинается, как только
+// готовы её операнды, а длительность берётся из OperationTime
+func BuildGraph(tasks []*models.Task) *Graph {
+	graph := &Graph{Tasks: make([]GraphTask, 0, len(tasks)), CriticalPath: []string{}}
+	byID := make(map[string]*models.Task, len(tasks))
+	for _, task := range tasks {
+		byID[task.ID] = task
+	}
+
+	finish := make(map[string]int, len(tasks))
+	slowest := make(map[string]string, len(tasks)) // Зависимость, которая завершается последней
+
+	var schedule func(task *models.Task) int
+	schedule = func(task *models.Task) int {
+		if f, ok := finish[task.ID]; ok {
+			return f
+		}
+		start := 0
+		for _, dep := range task.UniqueDependencies() {
+			depTask, ok := byID[dep]
+			if !ok {
+				continue
+			}
+			if f := schedule(depTask); slowest[task.ID] == "" || f > start {
+				start = f
+				slowest[task.ID] = dep
+			}
+		}
+		finish[task.ID] = start + task.OperationTime
+		return finish[task.ID]
+	}
+
+	var last string
+	for _, task := range tasks {
+		end := schedule(task)
+		deps := task.UniqueDependencies()
+		graph.Tasks = append(graph.Tasks, GraphTask{
+			Task:      task,
+			DependsOn: deps,
+			StartMs:   end - task.OperationTime,
+			FinishMs:  end,
+		})
+		graph.TotalWorkMs += task.OperationTime
+		if last == "" || end > graph.CriticalPathMs {
+			graph.CriticalPathMs = end
+			last = task.ID
+		}
+	}
+
+	// Восстанавливаем критический путь от задачи, которая завершается последней
+	for id := last; id != ""; id = slowest[id] {
+		graph.CriticalPath = append([]string{id}, graph.CriticalPath...)
+	}
+
+	graph.MaxParallelism = maxParallelism(graph.Tasks)
+	return graph
+}
+
+// maxParallelism считает наибольшее число задач, выполняющихся одновременно
+func maxParallelism(tasks []GraphTask) int {
+	type event struct {
+		at    int
+		delta int
+	}
+	events := make([]event, 0, 2*len(tasks))
+	for _, task := range tasks {
+		if task.FinishMs == task.StartMs {
+			continue
+		}
+		events = append(events, event{task.StartMs, 1}, event{task.FinishMs, -1})
+	}
+	// Задача, завершившаяся в момент начала другой, с ней не пересекается
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].delta < events[j].delta
+	})
+
+	running, peak := 0, 0
+	for _, e := range events {
+		running += e.delta
+		peak = max(peak, running)
+	}
+	if peak == 0 && len(tasks) > 0 {
+		peak = 1
+	}
+	return peak
+}
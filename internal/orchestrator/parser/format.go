@@ -0,0 +1,244 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Formatted — запись выражения в каноническом виде и для набора формул
+type Formatted struct {
+	Canonical string `json:"canonical"` // Запись с единообразными пробелами и минимумом скобок
+	LaTeX     string `json:"latex"`
+	MathML    string `json:"mathml"`
+}
+
+// Format разбирает выражение в заданной нотации и записывает его в каноническом виде,
+// LaTeX и MathML. Вызовы функций не раскрываются: запись соответствует тому, что ввёл пользователь.
+func Format(expr, syntax string) (*Formatted, error) {
+	_, ast, err := Read(expr, syntax)
+	if err != nil {
+		return nil, err
+	}
+	return &Formatted{
+		Canonical: ast.String(),
+		LaTeX:     latex(ast),
+		MathML:    `<math xmlns="http://www.w3.org/1998/Math/MathML">` + mathML(ast) + `</math>`,
+	}, nil
+}
+
+// String записывает дерево выражением, которое снова можно разобрать.
+// Скобки ставятся только там, где без них изменился бы порядок операций;
+// отрицательное число записывается разностью 0 - x, потому что унарного минуса в записи нет.
+func (n *Node) String() string {
+	switch n.Type {
+	case NodeNumber:
+		if n.Value < 0 {
+			return "0 - " + formatNumber(-n.Value)
+		}
+		if n.Unit != "" {
+			return formatNumber(n.Value) + " " + n.Unit
+		}
+		return formatNumber(n.Value)
+	case NodeVariable, NodeUnit, NodeDate:
+		return n.Name
+	case NodeCall:
+		return n.Name + "(" + joinNodes(n.Args) + ")"
+	case NodeList:
+		return "[" + joinNodes(n.Args) + "]"
+	case NodeElement:
+		return fmt.Sprintf("%s[%d]", n.Args[0], n.Index)
+	}
+
+	left, right := n.Args[0].String(), n.Args[1].String()
+	if needsParens(n.Args[0], n.Name, false) {
+		left = "(" + left + ")"
+	}
+	if needsParens(n.Args[1], n.Name, true) {
+		right = "(" + right + ")"
+	}
+	return left + " " + n.Name + " " + right
+}
+
+// needsParens сообщает, нужно ли заключить операнд оператора op в скобки.
+// Правый операнд - и / берётся в скобки и при равном приоритете: a - (b - c) ≠ a - b - c
+func needsParens(operand *Node, op string, right bool) bool {
+	inner := operand.Name
+	switch {
+	case operand.Type == NodeNumber && operand.Value < 0:
+		inner = "-"
+	case operand.Type != NodeOperator:
+		return false
+	}
+	if precedence(inner) != precedence(op) {
+		return precedence(inner) < precedence(op)
+	}
+	return right && (op == "-" || op == "/")
+}
+
+// latex записывает дерево в нотации LaTeX; деление — дробью, умножение — точкой
+func latex(n *Node) string {
+	switch n.Type {
+	case NodeNumber, NodeVariable:
+		return latexAtom(n)
+	case NodeUnit:
+		return latexUnit(n.Name)
+	case NodeDate:
+		return `\text{` + n.Name + `}`
+	case NodeCall:
+		switch {
+		case n.Name == "sqrt" && len(n.Args) == 1:
+			return `\sqrt{` + latex(n.Args[0]) + `}`
+		case n.Name == "abs" && len(n.Args) == 1:
+			return `\left| ` + latex(n.Args[0]) + ` \right|`
+		}
+		return `\operatorname{` + latexName(n.Name) + `}\left(` + joinWith(n.Args, latex, ", ") + `\right)`
+	case NodeList:
+		if depth(n) == 2 {
+			rows := make([]string, len(n.Args))
+			for i, row := range n.Args {
+				rows[i] = joinWith(row.Args, latex, " & ")
+			}
+			return `\begin{bmatrix} ` + strings.Join(rows, ` \\ `) + ` \end{bmatrix}`
+		}
+		return `\left[` + joinWith(n.Args, latex, ", ") + `\right]`
+	case NodeElement:
+		return latex(n.Args[0]) + fmt.Sprintf("_{%d}", n.Index)
+	}
+
+	if n.Name == "/" {
+		return `\frac{` + latex(n.Args[0]) + `}{` + latex(n.Args[1]) + `}`
+	}
+	left, right := latex(n.Args[0]), latex(n.Args[1])
+	if typesetParens(n.Args[0], n.Name, false) {
+		left = `\left(` + left + `\right)`
+	}
+	if typesetParens(n.Args[1], n.Name, true) {
+		right = `\left(` + right + `\right)`
+	}
+	return left + " " + latexOperators[n.Name] + " " + right
+}
+
+// Знаки операторов LaTeX
+var latexOperators = map[string]string{
+	"+":  "+",
+	"-":  "-",
+	"*":  `\cdot`,
+	"±":  `\pm`,
+	"to": `\to`,
+}
+
+// typesetParens — как needsParens, но для набранной формулы: дробь не требует скобок, её границы видны
+func typesetParens(operand *Node, op string, right bool) bool {
+	if operand.Type == NodeOperator && operand.Name == "/" {
+		return false
+	}
+	return needsParens(operand, op, right)
+}
+
+func latexAtom(n *Node) string {
+	if n.Type == NodeNumber {
+		if n.Value < 0 {
+			return "-" + formatNumber(-n.Value)
+		}
+		if n.Unit != "" {
+			return formatNumber(n.Value) + `\,` + latexUnit(n.Unit)
+		}
+		return formatNumber(n.Value)
+	}
+	if len(n.Name) == 1 {
+		return n.Name
+	}
+	return `\mathrm{` + latexName(n.Name) + `}`
+}
+
+// latexUnit набирает единицу прямым шрифтом; деление остаётся косой чертой
+func latexUnit(unit string) string {
+	return `\mathrm{` + strings.ReplaceAll(unit, "*", `\cdot `) + `}`
+}
+
+// latexName экранирует подчёркивания в имени
+func latexName(name string) string {
+	return strings.ReplaceAll(name, "_", `\_`)
+}
+
+// mathML записывает дерево в презентационной разметке MathML
+func mathML(n *Node) string {
+	switch n.Type {
+	case NodeNumber:
+		if n.Value < 0 {
+			return "<mrow><mo>&#x2212;</mo><mn>" + formatNumber(-n.Value) + "</mn></mrow>"
+		}
+		if n.Unit != "" {
+			return "<mrow><mn>" + formatNumber(n.Value) + "</mn><mo>&#x2062;</mo><mi mathvariant=\"normal\">" + n.Unit + "</mi></mrow>"
+		}
+		return "<mn>" + formatNumber(n.Value) + "</mn>"
+	case NodeVariable:
+		return "<mi>" + n.Name + "</mi>"
+	case NodeUnit:
+		return "<mi mathvariant=\"normal\">" + n.Name + "</mi>"
+	case NodeDate:
+		return "<mn>" + n.Name + "</mn>"
+	case NodeCall:
+		switch {
+		case n.Name == "sqrt" && len(n.Args) == 1:
+			return "<msqrt>" + mathML(n.Args[0]) + "</msqrt>"
+		case n.Name == "abs" && len(n.Args) == 1:
+			return "<mrow><mo>|</mo>" + mathML(n.Args[0]) + "<mo>|</mo></mrow>"
+		}
+		return "<mrow><mi>" + n.Name + "</mi><mo>&#x2061;</mo>" + mathMLFenced("(", n.Args, ")") + "</mrow>"
+	case NodeList:
+		if depth(n) == 2 {
+			var b strings.Builder
+			b.WriteString("<mrow><mo>[</mo><mtable>")
+			for _, row := range n.Args {
+				b.WriteString("<mtr>")
+				for _, cell := range row.Args {
+					b.WriteString("<mtd>" + mathML(cell) + "</mtd>")
+				}
+				b.WriteString("</mtr>")
+			}
+			b.WriteString("</mtable><mo>]</mo></mrow>")
+			return b.String()
+		}
+		return mathMLFenced("[", n.Args, "]")
+	case NodeElement:
+		return fmt.Sprintf("<msub>%s<mn>%d</mn></msub>", mathML(n.Args[0]), n.Index)
+	}
+
+	if n.Name == "/" {
+		return "<mfrac>" + mathML(n.Args[0]) + mathML(n.Args[1]) + "</mfrac>"
+	}
+	left, right := mathML(n.Args[0]), mathML(n.Args[1])
+	if typesetParens(n.Args[0], n.Name, false) {
+		left = "<mrow><mo>(</mo>" + left + "<mo>)</mo></mrow>"
+	}
+	if typesetParens(n.Args[1], n.Name, true) {
+		right = "<mrow><mo>(</mo>" + right + "<mo>)</mo></mrow>"
+	}
+	return "<mrow>" + left + "<mo>" + mathMLOperators[n.Name] + "</mo>" + right + "</mrow>"
+}
+
+// Знаки операторов MathML: минус и точка умножения — отдельные символы Unicode
+var mathMLOperators = map[string]string{
+	"+":  "+",
+	"-":  "&#x2212;",
+	"*":  "&#x22C5;",
+	"±":  "&#xB1;",
+	"to": "&#x2192;",
+}
+
+func mathMLFenced(open string, args []*Node, close string) string {
+	return "<mrow><mo>" + open + "</mo>" + joinWith(args, mathML, "<mo>,</mo>") + "<mo>" + close + "</mo></mrow>"
+}
+
+func joinNodes(nodes []*Node) string {
+	return joinWith(nodes, (*Node).String, ", ")
+}
+
+func joinWith(nodes []*Node, render func(*Node) string, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = render(n)
+	}
+	return strings.Join(parts, sep)
+}
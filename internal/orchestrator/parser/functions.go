@@ -0,0 +1,200 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"calc_service/pkg/errors"
+	"calc_service/pkg/models"
+)
+
+// FunctionResolver ищет пользовательскую функцию по имени
+type FunctionResolver func(name string) (*models.Function, bool)
+
+// builtins — встроенные функции и их арность; -1 — произвольное число аргументов
+var builtins = map[string]int{
+	"sqrt":   1,
+	"abs":    1,
+	"sum":    -1,
+	"avg":    -1,
+	"median": -1,
+	"stddev": -1,
+	"count":  -1,
+
+	"weekday": 1, // День недели даты по ISO 8601: 1 — понедельник, 7 — воскресенье
+
+	"matmul":    2,
+	"transpose": 1,
+	"det":       1,
+	"inv":       1,
+}
+
+// IsBuiltin сообщает, занято ли имя встроенной функцией
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// ValidateFunction проверяет, что функцию можно зарегистрировать:
+// тело разбирается, использует только параметры и вызывает только известные функции
+// без рекурсии
+func ValidateFunction(fn *models.Function, resolve FunctionResolver) error {
+	if err := fn.Validate(); err != nil {
+		return err
+	}
+	if IsBuiltin(fn.Name) {
+		return fmt.Errorf("%w: имя %s зарезервировано", errors.ErrInvalidFunction, fn.Name)
+	}
+
+	// Сама функция видна в своём теле, чтобы прямая рекурсия тоже была обнаружена
+	withSelf := func(name string) (*models.Function, bool) {
+		if name == fn.Name {
+			return fn, true
+		}
+		if resolve == nil {
+			return nil, false
+		}
+		return resolve(name)
+	}
+
+	args := make([]*Node, len(fn.Params))
+	for i, p := range fn.Params {
+		args[i] = &Node{Type: NodeVariable, Name: p}
+	}
+	_, err := expandCall(&Node{Type: NodeCall, Name: fn.Name, Args: args}, withSelf, nil)
+	return err
+}
+
+// expandCalls подставляет тела пользовательских функций вместо их вызовов.
+// Поддерево, на которое ссылаются несколько узлов, раскрывается один раз и остаётся общим
+func expandCalls(root *Node, resolve FunctionResolver, chain []string) (*Node, error) {
+	expanded := make(map[*Node]*Node)
+
+	var visit func(n *Node) (*Node, error)
+	visit = func(n *Node) (*Node, error) {
+		// Вызов без аргументов тоже проверяется и раскрывается: pi(), sqrt()
+		if len(n.Args) == 0 && n.Type != NodeCall {
+			return n, nil
+		}
+		if res, ok := expanded[n]; ok {
+			return res, nil
+		}
+
+		args := make([]*Node, len(n.Args))
+		for i, arg := range n.Args {
+			res, err := visit(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = res
+		}
+		res := &Node{Type: n.Type, Value: n.Value, Name: n.Name, Args: args}
+
+		if n.Type == NodeCall {
+			var err error
+			if res, err = expandCall(res, resolve, chain); err != nil {
+				return nil, err
+			}
+		}
+		expanded[n] = res
+		return res, nil
+	}
+	return visit(root)
+}
+
+// expandCall раскрывает один вызов, аргументы которого уже раскрыты
+func expandCall(call *Node, resolve FunctionResolver, chain []string) (*Node, error) {
+	if arity, ok := builtins[call.Name]; ok {
+		if arity >= 0 && len(call.Args) != arity {
+			return nil, fmt.Errorf("%w: %s ожидает %d", errors.ErrArgumentCount, call.Name, arity)
+		}
+		if arity < 0 && len(call.Args) == 0 {
+			return nil, fmt.Errorf("%w: %s ожидает хотя бы один аргумент", errors.ErrArgumentCount, call.Name)
+		}
+		return call, nil
+	}
+
+	var fn *models.Function
+	var ok bool
+	if resolve != nil {
+		fn, ok = resolve(call.Name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errors.ErrFunctionNotFound, call.Name)
+	}
+	if len(call.Args) != len(fn.Params) {
+		return nil, fmt.Errorf("%w: %s ожидает %d", errors.ErrArgumentCount, fn.Name, len(fn.Params))
+	}
+	for _, name := range chain {
+		if name == fn.Name {
+			return nil, fmt.Errorf("%w: %s -> %s", errors.ErrRecursiveFunction, strings.Join(chain, " -> "), fn.Name)
+		}
+	}
+
+	_, body, err := Syntax(fn.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errors.ErrInvalidFunction, fn.Name, err)
+	}
+
+	// Сначала раскрываем вызовы внутри тела, затем подставляем аргументы:
+	// каждый аргумент остаётся одним поддеревом, сколько бы раз он ни использовался
+	body, err = expandCalls(body, resolve, append(chain[:len(chain):len(chain)], fn.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]*Node, len(fn.Params))
+	for i, p := range fn.Params {
+		params[p] = call.Args[i]
+	}
+	body, err = substitute(body, params)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name, err)
+	}
+	return body, nil
+}
+
+// substitute заменяет переменные тела функции поддеревьями аргументов.
+// Поддерево, на которое ссылаются несколько узлов, остаётся общим
+func substitute(n *Node, params map[string]*Node) (*Node, error) {
+	substituted := make(map[*Node]*Node)
+
+	var visit func(n *Node) (*Node, error)
+	visit = func(n *Node) (*Node, error) {
+		if n.Type == NodeVariable {
+			arg, ok := params[n.Name]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", errors.ErrUnknownVariable, n.Name)
+			}
+			return arg, nil
+		}
+		if len(n.Args) == 0 {
+			return n, nil
+		}
+		if res, ok := substituted[n]; ok {
+			return res, nil
+		}
+
+		args := make([]*Node, len(n.Args))
+		for i, arg := range n.Args {
+			res, err := visit(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = res
+		}
+		res := &Node{Type: n.Type, Value: n.Value, Name: n.Name, Args: args}
+		substituted[n] = res
+		return res, nil
+	}
+	return visit(n)
+}
+
+// bindVariables подставляет значения переменных выражения
+func bindVariables(n *Node, values map[string]float64) (*Node, error) {
+	params := make(map[string]*Node, len(values))
+	for name, value := range values {
+		params[name] = &Node{Type: NodeNumber, Value: value}
+	}
+	return substitute(n, params)
+}
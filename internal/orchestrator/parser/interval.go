@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"calc_service/pkg/errors"
+)
+
+// ModeInterval — режим, в котором значения выражения — интервалы [нижняя, верхняя]:
+// литерал записывается как [lo, hi] или x ± err, число — как интервал нулевой ширины,
+// а агенты округляют границы наружу, так что результат гарантированно содержит точное значение
+const ModeInterval = "interval"
+
+// Операции, которые агенты умеют выполнять над интервалами
+var intervalOperations = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "sqrt": true, "abs": true,
+}
+
+// lowerIntervals заменяет каждую операцию интервальной: её аргументы — границы
+// операндов подряд, результат — массив [нижняя, верхняя]. Корень дерева становится
+// парой границ результата.
+func lowerIntervals(root *Node) (*Node, error) {
+	type bounds struct{ lo, hi *Node }
+	lowered := make(map[*Node]bounds)
+
+	var lower func(n *Node) (bounds, error)
+	lower = func(n *Node) (bounds, error) {
+		if b, ok := lowered[n]; ok {
+			return b, nil
+		}
+
+		var b bounds
+		switch {
+		case n.Type == NodeNumber:
+			b = bounds{number(roundDown(n.Value)), number(roundUp(n.Value))}
+		case n.Type == NodeList:
+			if len(n.Args) != 2 {
+				return bounds{}, fmt.Errorf("%w: интервал записывается как [нижняя, верхняя] из двух чисел", errors.ErrInvalidExpression)
+			}
+			lo, okLo := literal(n.Args[0])
+			hi, okHi := literal(n.Args[1])
+			if !okLo || !okHi {
+				return bounds{}, fmt.Errorf("%w: интервал записывается как [нижняя, верхняя] из двух чисел", errors.ErrInvalidExpression)
+			}
+			if lo > hi {
+				return bounds{}, fmt.Errorf("%w: нижняя граница [%s, %s] больше верхней", errors.ErrInvalidExpression, formatNumber(lo), formatNumber(hi))
+			}
+			b = bounds{number(roundDown(lo)), number(roundUp(hi))}
+		case n.Type == NodeOperator && n.Name == "±":
+			x, okX := literal(n.Args[0])
+			e, okE := literal(n.Args[1])
+			if !okX || !okE || e < 0 {
+				return bounds{}, fmt.Errorf("%w: погрешность записывается как x ± err с числами и err ≥ 0", errors.ErrInvalidExpression)
+			}
+			// Сами литералы могут быть неточными, а разность и сумма округляются, поэтому
+			// границы отодвигаются наружу и за литералы, и за операцию
+			lo := roundDown(x) - roundUp(e)
+			hi := roundUp(x) + roundUp(e)
+			b = bounds{number(math.Nextafter(lo, math.Inf(-1))), number(math.Nextafter(hi, math.Inf(1)))}
+		case n.Type == NodeVariable:
+			return bounds{}, fmt.Errorf("%w: %s", errors.ErrUnknownVariable, n.Name)
+		case intervalOperations[n.Name] && (n.Type == NodeOperator || n.Type == NodeCall):
+			call := &Node{Type: n.Type, Name: n.Name, Interval: true}
+			for _, arg := range n.Args {
+				ab, err := lower(arg)
+				if err != nil {
+					return bounds{}, err
+				}
+				call.Args = append(call.Args, ab.lo, ab.hi)
+			}
+			b = bounds{
+				&Node{Type: NodeElement, Args: []*Node{call}, Index: 0},
+				&Node{Type: NodeElement, Args: []*Node{call}, Index: 1},
+			}
+		default:
+			return bounds{}, fmt.Errorf("%w: %s не поддерживается в режиме interval", errors.ErrInvalidOption, n)
+		}
+
+		lowered[n] = b
+		return b, nil
+	}
+
+	b, err := lower(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Type: NodeList, Args: []*Node{b.lo, b.hi}}, nil
+}
+
+// checkDeviation запрещает погрешность x ± err вне режима interval: агенты не умеют
+// вычислять ± над числами
+func checkDeviation(n *Node, mode string) error {
+	if mode == ModeInterval {
+		return nil
+	}
+	if n.Type == NodeOperator && n.Name == "±" {
+		return fmt.Errorf("%w: ± допустим только в режиме %s", errors.ErrInvalidOption, ModeInterval)
+	}
+	for _, arg := range n.Args {
+		if err := checkDeviation(arg, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// literal возвращает значение числа или отрицательного числа, записанного как 0 - x:
+// унарного минуса в инфиксной записи нет
+func literal(n *Node) (float64, bool) {
+	if n.Type == NodeNumber {
+		return n.Value, true
+	}
+	if inner, ok := negated(n); ok && inner.Type == NodeNumber {
+		return -inner.Value, true
+	}
+	return 0, false
+}
+
+// roundDown возвращает число не больше десятичного литерала, который разобран в v:
+// если литерал представлен неточно, граница сдвигается на шаг вниз.
+// Литерал восстанавливается кратчайшей записью v.
+func roundDown(v float64) float64 {
+	if exact(v) {
+		return v
+	}
+	return math.Nextafter(v, math.Inf(-1))
+}
+
+// roundUp — то же, что roundDown, для верхней границы
+func roundUp(v float64) float64 {
+	if exact(v) {
+		return v
+	}
+	return math.Nextafter(v, math.Inf(1))
+}
+
+// exact сообщает, что десятичная запись числа представлена в float64 без округления
+func exact(v float64) bool {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return true
+	}
+	decimal, ok := new(big.Rat).SetString(formatNumber(v))
+	if !ok {
+		return false
+	}
+	return decimal.Cmp(new(big.Rat).SetFloat64(v)) == 0
+}
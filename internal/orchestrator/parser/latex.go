@@ -0,0 +1,476 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"calc_service/pkg/errors"
+)
+
+// Нотации записи выражения
+const (
+	SyntaxInfix = "infix" // Обычная запись: 2+2*2
+	SyntaxLaTeX = "latex" // Формула LaTeX: \frac{3}{4} \cdot \sqrt{16} + 2^{3}
+)
+
+// maxExponent ограничивает показатель степени, которая раскрывается в умножения
+const maxExponent = 1024
+
+// Read разбирает запись выражения в заданной нотации; пустая строка — обычная запись
+func Read(expr, syntax string) ([]string, *Node, error) {
+	switch syntax {
+	case "", SyntaxInfix:
+		return Syntax(expr)
+	case SyntaxLaTeX:
+		return ParseLaTeX(expr)
+	}
+	return nil, nil, fmt.Errorf("%w: неизвестный синтаксис %q", errors.ErrInvalidOption, syntax)
+}
+
+// ParseLaTeX разбирает формулу LaTeX в синтаксическое дерево того же вида, что и Syntax.
+// Поддерживаются + - \cdot \times \div /, неявное умножение (2x), унарный минус,
+// \frac, \sqrt, |x|, целые степени x^{n}, \operatorname{f}(…), списки [a, b]
+// и матрицы \begin{bmatrix} … \end{bmatrix}. Однобуквенные имена — переменные,
+// поэтому xy означает x*y; длинное имя записывается как \mathrm{name}.
+func ParseLaTeX(expr string) ([]string, *Node, error) {
+	tokens, err := tokenizeLaTeX(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, errors.ErrInvalidExpression
+	}
+
+	p := &latexParser{tokens: tokens}
+	ast, err := p.expression()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("%w: неожиданный %s", errors.ErrInvalidExpression, p.peek())
+	}
+	return rpnOf(ast), ast, nil
+}
+
+// Команды LaTeX, которые задают только отступы или размер скобок
+var latexIgnored = map[string]bool{
+	`\,`: true, `\;`: true, `\:`: true, `\!`: true, `\ `: true,
+	`\quad`: true, `\qquad`: true, `\left`: true, `\right`: true,
+}
+
+// Команды, которые сами являются именами функций
+var latexFunctions = map[string]string{
+	`\det`: "det",
+}
+
+// tokenizeLaTeX разбивает формулу на числа, отдельные буквы, команды \name и символы
+func tokenizeLaTeX(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch {
+		case unicode.IsSpace(char) || char == '~':
+		case unicode.IsDigit(char) || char == '.':
+			start := i
+			for i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		case char == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("%w: одиночная \\ в конце формулы", errors.ErrInvalidExpression)
+			}
+			start := i
+			i++
+			for unicode.IsLetter(runes[i]) && i+1 < len(runes) && unicode.IsLetter(runes[i+1]) {
+				i++
+			}
+			command := string(runes[start : i+1])
+			if !latexIgnored[command] {
+				tokens = append(tokens, command)
+			}
+		case unicode.IsLetter(char) || strings.ContainsRune("+-*/^_{}()[]|,&", char):
+			tokens = append(tokens, string(char))
+		default:
+			return nil, fmt.Errorf("неподдерживаемый символ: %c", char)
+		}
+	}
+	return tokens, nil
+}
+
+// latexParser — разбор формулы рекурсивным спуском
+type latexParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *latexParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *latexParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *latexParser) expect(token string) error {
+	if got := p.next(); got != token {
+		if got == "" {
+			got = "конец формулы"
+		}
+		return fmt.Errorf("%w: ожидалось %s, получено %s", errors.ErrInvalidExpression, token, got)
+	}
+	return nil
+}
+
+// expression: слагаемые через + и -
+func (p *latexParser) expression() (*Node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = operator(op, left, right)
+	}
+	return left, nil
+}
+
+// term: множители через \cdot, \times, *, \div, / или записанные подряд
+func (p *latexParser) term() (*Node, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		switch token := p.peek(); {
+		case token == `\cdot` || token == `\times` || token == "*":
+			op = "*"
+			p.next()
+		case token == `\div` || token == "/":
+			op = "/"
+			p.next()
+		case p.startsFactor(token):
+			op = "*" // Неявное умножение: 2x, 2\sqrt{2}, (a)(b)
+		default:
+			return left, nil
+		}
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = operator(op, left, right)
+	}
+}
+
+// startsFactor сообщает, может ли с токена начинаться множитель при неявном умножении.
+// Вертикальная черта не подходит: она же закрывает модуль
+func (p *latexParser) startsFactor(token string) bool {
+	switch token {
+	case "(", "{", "[", `\frac`, `\sqrt`, `\operatorname`, `\mathrm`, `\begin`:
+		return true
+	}
+	if _, ok := latexFunctions[token]; ok {
+		return true
+	}
+	r := []rune(token)
+	return len(r) > 0 && (unicode.IsDigit(r[0]) || r[0] == '.' || unicode.IsLetter(r[0]))
+}
+
+// factor: унарный знак, степень и погрешность x \pm err
+func (p *latexParser) factor() (*Node, error) {
+	switch p.peek() {
+	case "-":
+		p.next()
+		operand, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return operator("-", number(0), operand), nil
+	case "+":
+		p.next()
+		return p.factor()
+	}
+
+	n, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() == "^" {
+		p.next()
+		exponent, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		if n, err = power(n, exponent); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek() == `\pm` {
+		p.next()
+		deviation, err := p.atom()
+		if err != nil {
+			return nil, err
+		}
+		n = operator("±", n, deviation)
+	}
+	return n, nil
+}
+
+// group: аргумент команды — {выражение} или один атом
+func (p *latexParser) group() (*Node, error) {
+	if p.peek() != "{" {
+		return p.atom()
+	}
+	p.next()
+	n, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return n, p.expect("}")
+}
+
+func (p *latexParser) atom() (*Node, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("%w: формула оборвалась", errors.ErrInvalidExpression)
+	case isNumber(token):
+		return number(parseNumber(token)), nil
+	case token == "(" || token == "{":
+		n, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(map[string]string{"(": ")", "{": "}"}[token])
+	case token == "|":
+		n, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: NodeCall, Name: "abs", Args: []*Node{n}}, p.expect("|")
+	case token == "[":
+		args, err := p.arguments("]")
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: NodeList, Args: args}, nil
+	case token == `\frac`:
+		numerator, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		denominator, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		return operator("/", numerator, denominator), nil
+	case token == `\sqrt`:
+		if p.peek() == "[" {
+			p.next()
+			if degree := p.next(); degree != "2" || p.next() != "]" {
+				return nil, fmt.Errorf("%w: поддерживается только квадратный корень", errors.ErrInvalidExpression)
+			}
+		}
+		n, err := p.group()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: NodeCall, Name: "sqrt", Args: []*Node{n}}, nil
+	case token == `\operatorname` || token == `\mathrm`:
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != "(" {
+			if token == `\operatorname` {
+				return nil, fmt.Errorf("%w: после \\operatorname{%s} ожидаются аргументы", errors.ErrInvalidExpression, name)
+			}
+			return &Node{Type: NodeVariable, Name: name}, nil
+		}
+		return p.call(name)
+	case token == `\begin`:
+		return p.matrix()
+	case len([]rune(token)) == 1 && unicode.IsLetter([]rune(token)[0]):
+		return &Node{Type: NodeVariable, Name: token}, nil
+	}
+	if name, ok := latexFunctions[token]; ok {
+		return p.call(name)
+	}
+	return nil, fmt.Errorf("%w: неподдерживаемая команда %s", errors.ErrInvalidExpression, token)
+}
+
+// call разбирает аргументы вызова в круглых скобках
+func (p *latexParser) call(name string) (*Node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	args, err := p.arguments(")")
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Type: NodeCall, Name: name, Args: args}, nil
+}
+
+// arguments разбирает выражения через запятую до закрывающей скобки
+func (p *latexParser) arguments(closing string) ([]*Node, error) {
+	var args []*Node
+	if p.peek() == closing {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek() != "," {
+			return args, p.expect(closing)
+		}
+		p.next()
+	}
+}
+
+// name читает имя в фигурных скобках: \operatorname{hyp}, \mathrm{x\_1}
+func (p *latexParser) name() (string, error) {
+	if err := p.expect("{"); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for p.peek() != "}" {
+		token := p.next()
+		switch {
+		case token == "":
+			return "", fmt.Errorf("%w: незакрытое имя", errors.ErrInvalidExpression)
+		case token == `\_`:
+			token = "_"
+		case strings.HasPrefix(token, `\`):
+			return "", fmt.Errorf("%w: команда %s внутри имени", errors.ErrInvalidExpression, token)
+		}
+		b.WriteString(token)
+	}
+	p.next()
+	return b.String(), nil
+}
+
+// matrix разбирает окружение matrix, bmatrix или pmatrix: ячейки через &, строки через \\
+func (p *latexParser) matrix() (*Node, error) {
+	env, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if env != "matrix" && env != "bmatrix" && env != "pmatrix" {
+		return nil, fmt.Errorf("%w: окружение %s не поддерживается", errors.ErrInvalidExpression, env)
+	}
+
+	m := &Node{Type: NodeList}
+	row := &Node{Type: NodeList}
+	for {
+		cell, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		row.Args = append(row.Args, cell)
+
+		switch p.next() {
+		case "&":
+		case `\\`:
+			m.Args = append(m.Args, row)
+			row = &Node{Type: NodeList}
+			if p.peek() == `\end` { // Разделитель после последней строки
+				p.next()
+				return m, p.closeEnvironment(env)
+			}
+		case `\end`:
+			m.Args = append(m.Args, row)
+			return m, p.closeEnvironment(env)
+		default:
+			return nil, fmt.Errorf("%w: незакрытое окружение %s", errors.ErrInvalidExpression, env)
+		}
+	}
+}
+
+func (p *latexParser) closeEnvironment(env string) error {
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	if name != env {
+		return fmt.Errorf("%w: \\begin{%s} закрыт \\end{%s}", errors.ErrInvalidExpression, env, name)
+	}
+	return nil
+}
+
+// power раскрывает целую степень в умножения возведением в квадрат:
+// x^5 = (x^2)^2 * x, одинаковые множители — один узел и одна задача
+func power(base, exponent *Node) (*Node, error) {
+	n, ok := exponent.Value, exponent.Type == NodeNumber
+	if inner, neg := negated(exponent); neg && inner.Type == NodeNumber {
+		n, ok = -inner.Value, true
+	}
+	if !ok || n != math.Trunc(n) || math.Abs(n) > maxExponent {
+		return nil, fmt.Errorf("%w: показатель степени должен быть целым числом не больше %d", errors.ErrInvalidExpression, maxExponent)
+	}
+
+	var raise func(k int) *Node
+	raise = func(k int) *Node {
+		if k == 1 {
+			return base
+		}
+		half := raise(k / 2)
+		res := operator("*", half, half)
+		if k%2 == 1 {
+			res = operator("*", res, base)
+		}
+		return res
+	}
+
+	switch {
+	case n == 0:
+		return number(1), nil
+	case n < 0:
+		return operator("/", number(1), raise(int(-n))), nil
+	}
+	return raise(int(n)), nil
+}
+
+// rpnOf записывает дерево в обратной польской записи, как её строит toRPN
+func rpnOf(n *Node) []string {
+	var rpn []string
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		for _, arg := range n.Args {
+			visit(arg)
+		}
+		switch n.Type {
+		case NodeNumber:
+			if n.Unit != "" {
+				rpn = append(rpn, quantityToken(formatNumber(n.Value), n.Unit))
+				break
+			}
+			rpn = append(rpn, formatNumber(n.Value))
+		case NodeCall:
+			rpn = append(rpn, callToken(n.Name, len(n.Args)))
+		case NodeList:
+			rpn = append(rpn, listToken(len(n.Args)))
+		default:
+			rpn = append(rpn, n.Name)
+		}
+	}
+	visit(n)
+	return rpn
+}
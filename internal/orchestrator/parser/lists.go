@@ -0,0 +1,223 @@
+package parser
+
+import (
+	"fmt"
+
+	"calc_service/pkg/errors"
+)
+
+// aggregates — функции, сворачивающие список в одно число
+var aggregates = map[string]bool{
+	"sum":    true,
+	"avg":    true,
+	"median": true,
+	"stddev": true,
+	"count":  true,
+}
+
+// lowerLists избавляет дерево от списков внутри выражения: операции над списками и
+// матрицами раскрываются поэлементно, агрегаты — в деревья скалярных операций,
+// а матричные функции — в задачи над блоками.
+// Список может остаться только в корне, если результат выражения — список или матрица.
+func lowerLists(root *Node, blockSize int) (*Node, error) {
+	lowered := make(map[*Node]*Node)
+
+	var lower func(n *Node) (*Node, error)
+	lower = func(n *Node) (*Node, error) {
+		if res, ok := lowered[n]; ok {
+			return res, nil // Общее поддерево раскрываем один раз
+		}
+		if len(n.Args) == 0 {
+			return n, nil
+		}
+
+		args := make([]*Node, len(n.Args))
+		for i, arg := range n.Args {
+			res, err := lower(arg)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = res
+		}
+
+		var res *Node
+		var err error
+		switch {
+		case n.Type == NodeList:
+			res, err = list(args)
+		case n.Type == NodeCall && aggregates[n.Name]:
+			res, err = aggregate(n.Name, flatten(args))
+		case n.Type == NodeCall && matrixFunctions[n.Name]:
+			res, err = matrixCall(n.Name, args, blockSize)
+		default:
+			res, err = broadcast(n, args)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lowered[n] = res
+		return res, nil
+	}
+
+	return lower(root)
+}
+
+// list проверяет литерал списка: элементы — либо числа, либо строки матрицы одной длины
+func list(items []*Node) (*Node, error) {
+	res := &Node{Type: NodeList, Args: items}
+	if len(items) == 0 || items[0].Type != NodeList {
+		for _, item := range items {
+			if item.Type == NodeList {
+				return nil, fmt.Errorf("%w: в списке смешаны числа и списки", errors.ErrMatrixShape)
+			}
+		}
+		return res, nil
+	}
+
+	for _, row := range items {
+		if row.Type != NodeList || depth(row) != 1 {
+			return nil, fmt.Errorf("%w: строки матрицы должны быть списками чисел", errors.ErrMatrixShape)
+		}
+		if len(row.Args) == 0 || len(row.Args) != len(items[0].Args) {
+			return nil, fmt.Errorf("%w: строки матрицы разной длины", errors.ErrMatrixShape)
+		}
+	}
+	return res, nil
+}
+
+// depth возвращает вложенность узла: 0 — число, 1 — список, 2 — матрица
+func depth(n *Node) int {
+	if n.Type != NodeList {
+		return 0
+	}
+	if len(n.Args) > 0 && n.Args[0].Type == NodeList {
+		return 2
+	}
+	return 1
+}
+
+// shape возвращает размерность результата узла: nil для числа
+func shape(n *Node) []int {
+	switch depth(n) {
+	case 1:
+		return []int{len(n.Args)}
+	case 2:
+		return []int{len(n.Args), len(n.Args[0].Args)}
+	}
+	return nil
+}
+
+// broadcast применяет оператор или функцию к каждому элементу списков-операндов;
+// число-операнд повторяется для всех элементов
+func broadcast(n *Node, args []*Node) (*Node, error) {
+	length, argDepth := -1, 0
+	for _, arg := range args {
+		if arg.Type != NodeList {
+			continue
+		}
+		if argDepth > 0 && depth(arg) != argDepth {
+			return nil, fmt.Errorf("%w: операция над списком и матрицей", errors.ErrMatrixShape)
+		}
+		if length >= 0 && len(arg.Args) != length {
+			return nil, fmt.Errorf("%w: %d и %d", errors.ErrListLength, length, len(arg.Args))
+		}
+		length, argDepth = len(arg.Args), depth(arg)
+	}
+
+	if length < 0 {
+		return &Node{Type: n.Type, Value: n.Value, Name: n.Name, Args: args}, nil
+	}
+
+	items := make([]*Node, length)
+	for i := range items {
+		itemArgs := make([]*Node, len(args))
+		for j, arg := range args {
+			if arg.Type == NodeList {
+				itemArgs[j] = arg.Args[i]
+			} else {
+				itemArgs[j] = arg
+			}
+		}
+
+		// Строки матрицы раскрываются ещё раз — до отдельных элементов
+		item, err := broadcast(n, itemArgs)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return &Node{Type: NodeList, Args: items}, nil
+}
+
+// flatten собирает элементы списков и матриц в один список: sum([1,2],3) == sum(1,2,3)
+func flatten(args []*Node) []*Node {
+	var items []*Node
+	for _, arg := range args {
+		if arg.Type == NodeList {
+			items = append(items, flatten(arg.Args)...)
+		} else {
+			items = append(items, arg)
+		}
+	}
+	return items
+}
+
+// aggregate строит дерево вычисления агрегата над элементами списка
+func aggregate(name string, items []*Node) (*Node, error) {
+	n := float64(len(items))
+
+	switch name {
+	case "count":
+		return &Node{Type: NodeNumber, Value: n}, nil
+	case "sum":
+		if len(items) == 0 {
+			return &Node{Type: NodeNumber, Value: 0}, nil
+		}
+		return reduce("+", items), nil
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: %s", errors.ErrEmptyList, name)
+	}
+
+	switch name {
+	case "avg":
+		return mean(items), nil
+	case "median":
+		// Медиана не раскладывается на попарные операции — её считает один агент
+		return &Node{Type: NodeCall, Name: "median", Args: items}, nil
+	case "stddev":
+		// Стандартное отклонение генеральной совокупности: sqrt(sum((x-avg)^2)/n)
+		m := mean(items)
+		squares := make([]*Node, len(items))
+		for i, item := range items {
+			diff := &Node{Type: NodeOperator, Name: "-", Args: []*Node{item, m}}
+			squares[i] = &Node{Type: NodeOperator, Name: "*", Args: []*Node{diff, diff}}
+		}
+		variance := &Node{Type: NodeOperator, Name: "/", Args: []*Node{reduce("+", squares), {Type: NodeNumber, Value: n}}}
+		return &Node{Type: NodeCall, Name: "sqrt", Args: []*Node{variance}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", errors.ErrFunctionNotFound, name)
+}
+
+func mean(items []*Node) *Node {
+	return &Node{Type: NodeOperator, Name: "/", Args: []*Node{
+		reduce("+", items),
+		{Type: NodeNumber, Value: float64(len(items))},
+	}}
+}
+
+// reduce сворачивает элементы сбалансированным деревом глубины log n,
+// чтобы независимые операции могли выполняться разными агентами одновременно
+func reduce(op string, items []*Node) *Node {
+	if len(items) == 1 {
+		return items[0]
+	}
+	mid := len(items) / 2
+	return &Node{Type: NodeOperator, Name: op, Args: []*Node{
+		reduce(op, items[:mid]),
+		reduce(op, items[mid:]),
+	}}
+}
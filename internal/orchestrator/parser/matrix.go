@@ -0,0 +1,135 @@
+package parser
+
+import (
+	"fmt"
+
+	"calc_service/pkg/errors"
+)
+
+// DefaultBlockSize — сторона блока, на которые делится результат умножения матриц
+const DefaultBlockSize = 8
+
+// matrixFunctions — функции над матрицами
+var matrixFunctions = map[string]bool{
+	"matmul":    true,
+	"transpose": true,
+	"det":       true,
+	"inv":       true,
+}
+
+// matrixCall раскрывает вызов матричной функции над уже раскрытыми аргументами
+func matrixCall(name string, args []*Node, blockSize int) (*Node, error) {
+	if name == "matmul" {
+		return matmul(args[0], args[1], blockSize)
+	}
+
+	m := args[0]
+	if m.Type != NodeList {
+		return nil, fmt.Errorf("%w: %s ожидает матрицу", errors.ErrMatrixShape, name)
+	}
+	if name == "transpose" {
+		return transpose(m), nil
+	}
+
+	// det и inv определены только для квадратных матриц
+	rows := matrixRows(m)
+	n := len(rows)
+	if n == 0 || len(rows[0]) != n {
+		return nil, fmt.Errorf("%w: %s ожидает квадратную матрицу", errors.ErrMatrixShape, name)
+	}
+	call := &Node{Type: NodeCall, Name: name, Args: flatten([]*Node{m}), Shape: []int{n, n}}
+	if name == "det" {
+		return call, nil
+	}
+	return elements(call, n, n), nil
+}
+
+// matmul делит результат произведения на блоки blockSize×blockSize; каждый блок
+// вычисляет отдельная задача по полосе строк A и полосе столбцов B, поэтому
+// блоки не зависят друг от друга и выполняются разными агентами параллельно
+func matmul(a, b *Node, blockSize int) (*Node, error) {
+	if depth(a) != 2 || depth(b) != 2 {
+		return nil, fmt.Errorf("%w: matmul ожидает две матрицы", errors.ErrMatrixShape)
+	}
+	ar, br := matrixRows(a), matrixRows(b)
+	m, n, p := len(ar), len(ar[0]), len(br[0])
+	if len(br) != n {
+		return nil, fmt.Errorf("%w: %d×%d и %d×%d", errors.ErrMatrixShape, m, n, len(br), p)
+	}
+
+	result := make([][]*Node, m)
+	for i := range result {
+		result[i] = make([]*Node, p)
+	}
+
+	for i0 := 0; i0 < m; i0 += blockSize {
+		i1 := min(i0+blockSize, m)
+		for j0 := 0; j0 < p; j0 += blockSize {
+			j1 := min(j0+blockSize, p)
+
+			// Операнды блока: строки i0..i1 матрицы A, затем столбцы j0..j1 матрицы B
+			var operands []*Node
+			for i := i0; i < i1; i++ {
+				operands = append(operands, ar[i]...)
+			}
+			for k := 0; k < n; k++ {
+				operands = append(operands, br[k][j0:j1]...)
+			}
+
+			block := &Node{Type: NodeCall, Name: "matmul", Args: operands, Shape: []int{i1 - i0, n, j1 - j0}}
+			for i := i0; i < i1; i++ {
+				for j := j0; j < j1; j++ {
+					result[i][j] = &Node{Type: NodeElement, Args: []*Node{block}, Index: (i-i0)*(j1-j0) + (j - j0)}
+				}
+			}
+		}
+	}
+
+	return matrix(result), nil
+}
+
+// transpose меняет строки и столбцы местами; задачи для этого не нужны.
+// Список считается матрицей из одной строки.
+func transpose(m *Node) *Node {
+	rows := matrixRows(m)
+	result := make([][]*Node, len(rows[0]))
+	for j := range result {
+		result[j] = make([]*Node, len(rows))
+		for i := range rows {
+			result[j][i] = rows[i][j]
+		}
+	}
+	return matrix(result)
+}
+
+// elements раскладывает результат-массив задачи в матрицу rows×cols
+func elements(call *Node, rows, cols int) *Node {
+	result := make([][]*Node, rows)
+	for i := range result {
+		result[i] = make([]*Node, cols)
+		for j := range result[i] {
+			result[i][j] = &Node{Type: NodeElement, Args: []*Node{call}, Index: i*cols + j}
+		}
+	}
+	return matrix(result)
+}
+
+// matrixRows возвращает элементы матрицы по строкам
+func matrixRows(m *Node) [][]*Node {
+	if depth(m) == 1 {
+		return [][]*Node{m.Args}
+	}
+	rows := make([][]*Node, len(m.Args))
+	for i, row := range m.Args {
+		rows[i] = row.Args
+	}
+	return rows
+}
+
+func matrix(rows [][]*Node) *Node {
+	res := &Node{Type: NodeList, Args: make([]*Node, len(rows))}
+	for i, row := range rows {
+		res.Args[i] = &Node{Type: NodeList, Args: row}
+	}
+	return res
+}
@@ -0,0 +1,176 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Уровни оптимизации дерева перед построением задач
+const (
+	OptimizeNone     = 0 // Дерево не меняется
+	OptimizeSimplify = 1 // Алгебраические тождества и объединение одинаковых подвыражений
+	OptimizeFold     = 2 // Дополнительно литеральные поддеревья вычисляются оркестратором
+	OptimizeMax      = OptimizeFold
+)
+
+// optimize упрощает дерево, чтобы агентам не доставались тривиальные задачи.
+// Тождества применяются только там, где результат совпадает при любом значении x,
+// включая NaN и бесконечности: x*0 заменяется нулём лишь для конечного x.
+func optimize(root *Node, level int) *Node {
+	if level <= OptimizeNone {
+		return root
+	}
+
+	optimized := make(map[*Node]*Node)
+	interned := make(map[string]*Node) // Канонический узел для каждого подвыражения
+	ids := make(map[*Node]int)         // Номера канонических узлов для ключей родителей
+
+	var visit func(n *Node) *Node
+	visit = func(n *Node) *Node {
+		if res, ok := optimized[n]; ok {
+			return res
+		}
+
+		res := n
+		if len(n.Args) > 0 {
+			args := make([]*Node, len(n.Args))
+			for i, arg := range n.Args {
+				args[i] = visit(arg)
+			}
+			res = &Node{Type: n.Type, Value: n.Value, Name: n.Name, Args: args, Index: n.Index, Shape: n.Shape}
+
+			if level >= OptimizeFold {
+				if value, ok := fold(res); ok {
+					res = &Node{Type: NodeNumber, Value: value}
+				}
+			}
+			res = simplify(res)
+		}
+
+		// Одинаковые подвыражения сводятся к одному узлу — и к одной задаче
+		key := nodeKey(res, ids)
+		if canonical, ok := interned[key]; ok {
+			res = canonical
+		} else {
+			interned[key] = res
+			ids[res] = len(ids)
+		}
+
+		optimized[n] = res
+		return res
+	}
+
+	return visit(root)
+}
+
+// simplify применяет тождества x*1, 1*x, x/1, x+0, 0+x, x-0 и x*0 для конечного x
+func simplify(n *Node) *Node {
+	if n.Type != NodeOperator {
+		return n
+	}
+	a, b := n.Args[0], n.Args[1]
+
+	switch n.Name {
+	case "+":
+		if isLiteral(b, 0) {
+			return a
+		}
+		if isLiteral(a, 0) {
+			return b
+		}
+	case "-":
+		if isLiteral(b, 0) {
+			return a
+		}
+	case "*":
+		if isLiteral(b, 1) {
+			return a
+		}
+		if isLiteral(a, 1) {
+			return b
+		}
+		// NaN*0 и Inf*0 дают NaN, поэтому ноль подставляется только для конечного множителя
+		if isLiteral(b, 0) && finite(a) || isLiteral(a, 0) && finite(b) {
+			return &Node{Type: NodeNumber, Value: 0}
+		}
+	case "/":
+		if isLiteral(b, 1) {
+			return a
+		}
+	}
+	return n
+}
+
+// fold вычисляет операцию, все операнды которой — литералы.
+// Деление на ноль и корень из отрицательного числа остаются задачами, чтобы ошибку вернул агент.
+func fold(n *Node) (float64, bool) {
+	if n.Type != NodeOperator && n.Type != NodeCall {
+		return 0, false
+	}
+	values := make([]float64, len(n.Args))
+	for i, arg := range n.Args {
+		if arg.Type != NodeNumber {
+			return 0, false
+		}
+		values[i] = arg.Value
+	}
+
+	switch n.Name {
+	case "+":
+		return values[0] + values[1], true
+	case "-":
+		return values[0] - values[1], true
+	case "*":
+		return values[0] * values[1], true
+	case "/":
+		if values[1] == 0 {
+			return 0, false
+		}
+		return values[0] / values[1], true
+	case "abs":
+		return math.Abs(values[0]), true
+	case "sqrt":
+		if values[0] < 0 {
+			return 0, false
+		}
+		return math.Sqrt(values[0]), true
+	case "median":
+		sort.Float64s(values)
+		mid := len(values) / 2
+		if len(values)%2 == 0 {
+			return (values[mid-1] + values[mid]) / 2, true
+		}
+		return values[mid], true
+	}
+	return 0, false
+}
+
+// finite сообщает, что значение узла заведомо конечно
+func finite(n *Node) bool {
+	switch n.Type {
+	case NodeNumber:
+		return !math.IsInf(n.Value, 0) && !math.IsNaN(n.Value)
+	case NodeCall:
+		return n.Name == "abs" && finite(n.Args[0])
+	}
+	return false
+}
+
+func isLiteral(n *Node, value float64) bool {
+	return n.Type == NodeNumber && n.Value == value
+}
+
+// nodeKey строит ключ подвыражения по уже каноническим операндам;
+// у коммутативных + и * порядок операндов не важен
+func nodeKey(n *Node, ids map[*Node]int) string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = fmt.Sprint(ids[arg])
+	}
+	if n.Type == NodeOperator && (n.Name == "+" || n.Name == "*") {
+		sort.Strings(args)
+	}
+	return fmt.Sprintf("%s|%s|%v|%d|%v|%s", n.Type, n.Name, n.Value, n.Index, n.Shape, strings.Join(args, ","))
+}
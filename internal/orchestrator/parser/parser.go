@@ -4,79 +4,291 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 	"unicode"
 
+	"calc_service/internal/orchestrator/rates"
 	"calc_service/pkg/errors"
 	"calc_service/pkg/models"
+
+	"github.com/google/uuid"
 )
 
+// Options задаёт параметры разбора выражения
+type Options struct {
+	Syntax    string             // Нотация записи: SyntaxInfix (по умолчанию) или SyntaxLaTeX
+	Mode      string             // Что вычисляется: числа (по умолчанию) или ModeInterval
+	Functions FunctionResolver   // Пользовательские функции; nil — только встроенные
+	BlockSize int                // Размер блока при умножении матриц; 0 — DefaultBlockSize
+	Optimize  int                // Уровень оптимизации: OptimizeNone…OptimizeMax
+	Variables map[string]float64 // Значения переменных выражения
+	Rates     rates.RateProvider // Курсы валют для пересчёта денежных сумм; nil — без пересчёта
+	// PreserveOrder сохраняет порядок операций как в записи выражения —
+	// для воспроизводимости результатов с плавающей точкой
+	PreserveOrder bool
+}
+
+// Plan — результат разбора выражения
+type Plan struct {
+	RPN     []string       // Обратная польская запись исходного выражения
+	AST     *Node          // Синтаксическое дерево записи выражения
+	Tree    *Node          // Дерево после подстановки функций, раскрытия списков и оптимизаций
+	Tasks   []*models.Task // Задачи в порядке обхода дерева: операнды раньше операций
+	Outputs []Output       // Составляющие результата по строкам
+	Shape   []int          // Размерность результата: nil — число, [n] — список, [строки, столбцы] — матрица
+	Unit    string         // Единица результата: из to или в единицах СИ; пусто — безразмерный
+	Type    string         // models.ResultDate или models.ResultDuration; пусто — число
+	// Interval: результат — границы интервала, Outputs — нижняя и верхняя
+	Interval bool
+}
+
+// Output — составляющая результата: значение, известное сразу, или задача, которая его вычислит
+type Output struct {
+	Value float64
+	Task  string
+	Index int // Элемент результата-массива задачи
+}
+
+// Constant возвращает значение выражения, которому не потребовалось ни одной задачи
+func (p *Plan) Constant() (float64, bool) {
+	if len(p.Tasks) == 0 && p.Shape == nil && !p.Interval {
+		return p.Outputs[0].Value, true
+	}
+	return 0, false
+}
+
 // Parse разбивает выражение на задачи
 func Parse(expr string) ([]*models.Task, error) {
+	plan, err := Compile(expr, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return plan.Tasks, nil
+}
+
+// Check проверяет выражение до постановки задач, чтобы ошибку в вызовах функций, погрешности
+// x ± err вне режима interval или в единицах измерения можно было вернуть сразу.
+// Выражение разбирается один раз. Ошибки разбора не возвращаются: о них сообщит
+// обработка выражения
+func Check(expr string, opts Options) error {
+	_, ast, err := Read(expr, opts.Syntax)
+	if err != nil {
+		if isUnitError(err) {
+			return err
+		}
+		return nil
+	}
+	tree, err := expandCalls(ast, opts.Functions, nil)
+	if err != nil {
+		return err
+	}
+	if err := checkDeviation(tree, opts.Mode); err != nil {
+		return err
+	}
+	if _, _, _, err := lowerUnits(tree, opts.Rates); isUnitError(err) {
+		return err
+	}
+	return nil
+}
+
+// Compile разбирает выражение, подставляет пользовательские функции, раскрывает списки
+// и строит задачи
+func Compile(expr string, opts Options) (*Plan, error) {
+	rpn, ast, err := Read(expr, opts.Syntax)
+	if err != nil {
+		return nil, err
+	}
+
+	// Раскрываем вызовы пользовательских функций
+	tree, err := expandCalls(ast, opts.Functions, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Variables != nil {
+		tree, err = bindVariables(tree, opts.Variables)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Проверяем размерности и переводим величины с единицами в СИ
+	decimal := usesCurrency(tree)
+	tree, dim, unit, err := lowerUnits(tree, opts.Rates)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Mode {
+	case "":
+		if err := checkDeviation(tree, opts.Mode); err != nil {
+			return nil, err
+		}
+		// Раскрываем поэлементные операции, агрегаты и операции над матрицами
+		blockSize := opts.BlockSize
+		if blockSize <= 0 {
+			blockSize = DefaultBlockSize
+		}
+		tree, err = lowerLists(tree, blockSize)
+		if err != nil {
+			return nil, err
+		}
+	case ModeInterval:
+	default:
+		return nil, fmt.Errorf("%w: неизвестный режим %q", errors.ErrInvalidOption, opts.Mode)
+	}
+
+	// Перестраиваем цепочки операций для параллельного выполнения и упрощаем дерево
+	if !opts.PreserveOrder {
+		tree = rebalance(tree)
+	}
+	level := opts.Optimize
+	if opts.Mode == ModeInterval || decimal {
+		// Свёртка литералов считала бы в двоичной арифметике: без направленного округления
+		// для интервалов и с ошибкой 0.1 + 0.2 для денежных сумм
+		level = min(level, OptimizeSimplify)
+	}
+	tree = optimize(tree, level)
+
+	// В интервальном режиме каждая операция вычисляет пару границ
+	if opts.Mode == ModeInterval {
+		if tree, err = lowerIntervals(tree); err != nil {
+			return nil, err
+		}
+	}
+
+	// Преобразуем дерево в задачи
+	tasks, outputs, err := treeToTasks(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	// Денежные суммы агенты складывают и умножают в десятичной арифметике
+	for _, task := range tasks {
+		task.Decimal = decimal
+	}
+
+	plan := &Plan{RPN: rpn, AST: ast, Tree: tree, Tasks: tasks, Outputs: outputs, Unit: unit, Type: resultType(dim, unit), Interval: opts.Mode == ModeInterval}
+	if !plan.Interval {
+		plan.Shape = shape(tree)
+	}
+	return plan, nil
+}
+
+// Syntax разбирает запись выражения в обратную польскую запись и синтаксическое дерево
+// без подстановки функций и построения задач
+func Syntax(expr string) ([]string, *Node, error) {
+	// Приведение к единице отделяется до удаления пробелов: to — отдельное слово
+	expr, target := splitConversion(expr)
 	expr = strings.ReplaceAll(expr, " ", "") // Удаляем пробелы
 
 	// Проверка на пустое выражение
 	if len(expr) == 0 {
-		return nil, errors.ErrInvalidExpression
+		return nil, nil, errors.ErrInvalidExpression
 	}
 
 	// Проверка баланса скобок
 	if !checkParentheses(expr) {
-		return nil, errors.ErrInvalidParentheses
+		return nil, nil, errors.ErrInvalidParentheses
 	}
 
 	// Преобразуем выражение в обратную польскую запись (RPN)
 	rpn, err := toRPN(expr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Преобразуем RPN в задачи
-	return rpnToTasks(rpn)
+	// Строим дерево
+	ast, err := buildTree(rpn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if target != "" {
+		ast = operator("to", ast, &Node{Type: NodeUnit, Name: target})
+		rpn = append(rpn, target, "to")
+	}
+	return rpn, ast, nil
 }
 
 // toRPN преобразует выражение в обратную польскую запись
 func toRPN(expr string) ([]string, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
 	var output []string
 	var operators []string
+	// Для каждой открытой скобки: число аргументов вызова или элементов списка,
+	// -1 для группирующих круглых скобок
+	var calls []int
 
-	for i := 0; i < len(expr); i++ {
-		char := rune(expr[i])
-
-		if unicode.IsDigit(char) || char == '.' {
-			// Считываем число целиком
-			numStr := readNumber(expr, &i)
-			output = append(output, numStr)
-			continue
+	// popUntilOpen выталкивает операторы до ближайшей открывающей скобки
+	popUntilOpen := func() {
+		for len(operators) > 0 && !isOpening(operators[len(operators)-1]) {
+			output = append(output, operators[len(operators)-1])
+			operators = operators[:len(operators)-1]
 		}
+	}
 
-		switch char {
-		case '(':
-			operators = append(operators, string(char))
-		case ')':
-			for len(operators) > 0 && operators[len(operators)-1] != "(" {
-				output = append(output, operators[len(operators)-1])
-				operators = operators[:len(operators)-1]
+	for i, token := range tokens {
+		switch {
+		case isNumber(token) || isQuantity(token) || datePattern.MatchString(token):
+			output = append(output, token)
+		case models.IsIdentifier(token):
+			if i+1 < len(tokens) && tokens[i+1] == "(" {
+				operators = append(operators, token) // Имя функции ждёт своих аргументов
+			} else {
+				output = append(output, token)
+			}
+		case token == "(" || token == "[":
+			switch {
+			case i+1 < len(tokens) && tokens[i+1] == closing(token):
+				if token == "(" && (i == 0 || !models.IsIdentifier(tokens[i-1])) {
+					return nil, errors.ErrInvalidExpression // Пустые скобки
+				}
+				calls = append(calls, 0)
+			case token == "(" && (i == 0 || !models.IsIdentifier(tokens[i-1])):
+				calls = append(calls, -1)
+			default:
+				calls = append(calls, 1)
+			}
+			operators = append(operators, token)
+		case token == ",":
+			popUntilOpen()
+			if len(calls) == 0 || calls[len(calls)-1] < 1 {
+				return nil, errors.ErrInvalidExpression
 			}
-			if len(operators) == 0 {
+			calls[len(calls)-1]++
+		case token == ")" || token == "]":
+			popUntilOpen()
+			if len(operators) == 0 || closing(operators[len(operators)-1]) != token {
 				return nil, errors.ErrInvalidParentheses
 			}
-			operators = operators[:len(operators)-1] // Убираем "("
-		case '+', '-', '*', '/':
-			for len(operators) > 0 && precedence(operators[len(operators)-1]) >= precedence(string(char)) {
-				output = append(output, operators[len(operators)-1])
+			open := operators[len(operators)-1]
+			operators = operators[:len(operators)-1] // Убираем открывающую скобку
+
+			count := calls[len(calls)-1]
+			calls = calls[:len(calls)-1]
+			switch {
+			case open == "[":
+				output = append(output, listToken(count))
+			case count >= 0:
+				name := operators[len(operators)-1]
 				operators = operators[:len(operators)-1]
+				output = append(output, callToken(name, count))
 			}
-			operators = append(operators, string(char))
 		default:
-			return nil, fmt.Errorf("неподдерживаемый символ: %c", char)
+			for len(operators) > 0 && precedence(operators[len(operators)-1]) >= precedence(token) {
+				output = append(output, operators[len(operators)-1])
+				operators = operators[:len(operators)-1]
+			}
+			operators = append(operators, token)
 		}
 	}
 
 	// Добавляем оставшиеся операторы
 	for len(operators) > 0 {
 		op := operators[len(operators)-1]
-		if op == "(" {
+		if isOpening(op) {
 			return nil, errors.ErrInvalidParentheses
 		}
 		output = append(output, op)
@@ -86,46 +298,125 @@ func toRPN(expr string) ([]string, error) {
 	return output, nil
 }
 
-// rpnToTasks преобразует RPN в задачи
-func rpnToTasks(rpn []string) ([]*models.Task, error) {
-	var stack []string
-	var tasks []*models.Task
+// tokenize разбивает выражение без пробелов на числа, имена, операторы и скобки
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
 
-	for _, token := range rpn {
-		if isNumber(token) {
-			stack = append(stack, token)
+	for i := 0; i < len(expr); i++ {
+		char := rune(expr[i])
+		if strings.HasPrefix(expr[i:], "±") {
+			tokens = append(tokens, "±")
+			i += len("±") - 1
 			continue
 		}
 
-		// Для оператора нужны два операнда
-		if len(stack) < 2 {
-			return nil, errors.ErrInvalidExpression
+		switch {
+		case unicode.IsDigit(char):
+			if date, ok := readDate(expr, &i); ok {
+				tokens = append(tokens, date)
+				continue
+			}
+			fallthrough
+		case char == '.':
+			// Считываем число целиком, а с ним и единицу измерения, если она записана за числом
+			number := readNumber(expr, &i)
+			if i+1 < len(expr) && isLatin(expr[i+1]) {
+				i++
+				unit, err := readUnit(expr, &i)
+				if err != nil {
+					return nil, err
+				}
+				number = quantityToken(number, unit)
+			}
+			tokens = append(tokens, number)
+		case unicode.IsLetter(char) || char == '_':
+			tokens = append(tokens, readIdentifier(expr, &i))
+		case strings.ContainsRune("+-*/(),[]", char):
+			tokens = append(tokens, string(char))
+		default:
+			return nil, fmt.Errorf("неподдерживаемый символ: %c", char)
 		}
+	}
+
+	return groupQuantities(tokens), nil
+}
+
+// treeToTasks обходит дерево снизу вверх и создаёт задачу для каждой операции.
+// Узел, на который ссылаются несколько родителей, вычисляется одной задачей.
+// Возвращает задачи и выходы выражения: один для числа, по одному на элемент списка.
+func treeToTasks(root *Node) ([]*models.Task, []Output, error) {
+	var tasks []*models.Task
+	emitted := make(map[*Node]string)
 
-		// Создаем задачу
-		arg2 := stack[len(stack)-1]
-		arg1 := stack[len(stack)-2]
-		stack = stack[:len(stack)-2]
+	// emit возвращает операнд: либо число, либо ID задачи, которая его вычислит
+	var emit func(n *Node) (Output, error)
+	emit = func(n *Node) (Output, error) {
+		switch n.Type {
+		case NodeNumber:
+			return Output{Value: n.Value}, nil
+		case NodeVariable:
+			return Output{}, fmt.Errorf("%w: %s", errors.ErrUnknownVariable, n.Name)
+		case NodeList:
+			return Output{}, fmt.Errorf("%w: список нельзя использовать как операнд", errors.ErrInvalidExpression)
+		case NodeElement:
+			array, err := emit(n.Args[0])
+			if err != nil {
+				return Output{}, err
+			}
+			return Output{Task: array.Task, Index: n.Index}, nil
+		}
+		if id, ok := emitted[n]; ok {
+			return Output{Task: id}, nil
+		}
 
 		task := &models.Task{
 			ID:            generateTaskID(),
-			Operation:     token,
-			Arg1:          parseNumber(arg1),
-			Arg2:          parseNumber(arg2),
-			OperationTime: getOperationTime(token),
+			Operation:     n.Name,
+			OperationTime: getOperationTime(n.Name),
 			Status:        "pending",
 		}
-		tasks = append(tasks, task)
 
-		// Результат задачи становится новым операндом
-		stack = append(stack, fmt.Sprintf("task_%s", task.ID))
-	}
+		args := make([]Output, len(n.Args))
+		for i, arg := range n.Args {
+			out, err := emit(arg)
+			if err != nil {
+				return Output{}, err
+			}
+			args[i] = out
+		}
+
+		if models.IsListOperation(n.Name) || n.Interval {
+			task.Interval = n.Interval
+			task.Args = make([]float64, len(args))
+			task.ArgTasks = make([]string, len(args))
+			task.ArgIndex = make([]int, len(args))
+			for i, arg := range args {
+				task.Args[i], task.ArgTasks[i], task.ArgIndex[i] = arg.Value, arg.Task, arg.Index
+			}
+			task.Shape = n.Shape
+		} else {
+			task.Arg1, task.Arg1Task, task.Arg1Index = args[0].Value, args[0].Task, args[0].Index
+			if len(args) > 1 {
+				task.Arg2, task.Arg2Task, task.Arg2Index = args[1].Value, args[1].Task, args[1].Index
+			}
+		}
 
-	if len(stack) != 1 {
-		return nil, errors.ErrInvalidExpression
+		tasks = append(tasks, task)
+		emitted[n] = task.ID
+		return Output{Task: task.ID}, nil
 	}
 
-	return tasks, nil
+	items := flatten([]*Node{root})
+
+	outputs := make([]Output, len(items))
+	for i, item := range items {
+		out, err := emit(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		outputs[i] = out
+	}
+	return tasks, outputs, nil
 }
 
 // Вспомогательные функции
@@ -146,12 +437,25 @@ func checkParentheses(expr string) bool {
 	return balance == 0
 }
 
+func isOpening(token string) bool {
+	return token == "(" || token == "["
+}
+
+func closing(open string) string {
+	if open == "[" {
+		return "]"
+	}
+	return ")"
+}
+
 func precedence(op string) int {
 	switch op {
 	case "+", "-":
 		return 1
 	case "*", "/":
 		return 2
+	case "±":
+		return 3
 	}
 	return 0
 }
@@ -166,15 +470,24 @@ func readNumber(expr string, i *int) string {
 	return numStr.String()
 }
 
+func readIdentifier(expr string, i *int) string {
+	start := *i
+	for *i < len(expr) && (unicode.IsLetter(rune(expr[*i])) || unicode.IsDigit(rune(expr[*i])) || expr[*i] == '_') {
+		*i++
+	}
+	*i-- // Возвращаем индекс на последний символ имени
+	return expr[start : *i+1]
+}
+
 func isNumber(s string) bool {
+	if s == "" || !(unicode.IsDigit(rune(s[0])) || s[0] == '.') {
+		return false
+	}
 	_, err := strconv.ParseFloat(s, 64)
 	return err == nil
 }
 
 func parseNumber(s string) float64 {
-	if strings.HasPrefix(s, "task_") {
-		return 0 // Временное значение, будет заменено при вычислении
-	}
 	num, _ := strconv.ParseFloat(s, 64)
 	return num
 }
@@ -189,10 +502,18 @@ func getOperationTime(op string) int {
 		return 2000
 	case "/":
 		return 2000
+	case "sqrt", "abs":
+		return 2000
+	case "weekday":
+		return 1000
+	case "median", "det":
+		return 3000
+	case "matmul", "inv":
+		return 4000
 	}
 	return 0
 }
 
 func generateTaskID() string {
-	return fmt.Sprintf("task_%d", time.Now().UnixNano())
+	return "task_" + uuid.New().String()
 }
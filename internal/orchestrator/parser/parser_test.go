@@ -1,10 +1,16 @@
 package parser_test
 
 import (
+	"math"
+	"math/big"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"calc_service/internal/orchestrator/parser"
 	"calc_service/pkg/errors"
+	"calc_service/pkg/models"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -64,3 +70,977 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestCompileFunctions(t *testing.T) {
+	functions := map[string]*models.Function{
+		"hyp":   {Name: "hyp", Params: []string{"a", "b"}, Body: "sqrt(a*a+b*b)"},
+		"half":  {Name: "half", Params: []string{"x"}, Body: "x/2"},
+		"loop":  {Name: "loop", Params: []string{"x"}, Body: "loop2(x)+1"},
+		"loop2": {Name: "loop2", Params: []string{"x"}, Body: "loop(x)"},
+		"pi":    {Name: "pi", Params: []string{}, Body: "3.14159"},
+		"tau":   {Name: "tau", Params: []string{}, Body: "2*pi()"},
+	}
+	resolve := func(name string) (*models.Function, bool) {
+		fn, ok := functions[name]
+		return fn, ok
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected int // Количество задач
+		err      error
+	}{
+		{
+			name:     "Встроенная функция",
+			input:    "sqrt(16)+1",
+			expected: 2,
+		},
+		{
+			name:     "Пользовательская функция",
+			input:    "hyp(3,4)",
+			expected: 4,
+		},
+		{
+			name:     "Вложенные вызовы",
+			input:    "half(hyp(3, 4))",
+			expected: 5,
+		},
+		{
+			name:  "Неизвестная функция",
+			input: "foo(1)",
+			err:   errors.ErrFunctionNotFound,
+		},
+		{
+			name:  "Неверное число аргументов",
+			input: "hyp(3)",
+			err:   errors.ErrArgumentCount,
+		},
+		{
+			name:     "Функция без параметров",
+			input:    "tau()+pi()",
+			expected: 2,
+		},
+		{
+			name:  "Лишний аргумент функции без параметров",
+			input: "pi(1)",
+			err:   errors.ErrArgumentCount,
+		},
+		{
+			name:  "Рекурсия",
+			input: "loop(1)",
+			err:   errors.ErrRecursiveFunction,
+		},
+		{
+			name:  "Переменная вне функции",
+			input: "a+1",
+			err:   errors.ErrUnknownVariable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{Functions: resolve})
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, len(plan.Tasks))
+		})
+	}
+
+	t.Run("Вызов без аргументов", func(t *testing.T) {
+		calls := map[string]error{
+			"sqrt()":    errors.ErrArgumentCount,
+			"sum()":     errors.ErrArgumentCount,
+			"count()":   errors.ErrArgumentCount,
+			"weekday()": errors.ErrArgumentCount,
+			"hyp()":     errors.ErrArgumentCount,
+			"1+abs()":   errors.ErrArgumentCount,
+			"foo()":     errors.ErrFunctionNotFound,
+		}
+		for input, expected := range calls {
+			_, err := parser.Compile(input, parser.Options{Functions: resolve})
+			assert.ErrorIs(t, err, expected, input)
+			assert.ErrorIs(t, parser.Check(input, parser.Options{Functions: resolve}), expected, input)
+		}
+
+		_, err := parser.Compile(`\operatorname{sqrt}()`, parser.Options{Syntax: parser.SyntaxLaTeX})
+		assert.ErrorIs(t, err, errors.ErrArgumentCount)
+	})
+
+	t.Run("Зависимости задач", func(t *testing.T) {
+		plan, err := parser.Compile("hyp(3,4)", parser.Options{Functions: resolve})
+		assert.NoError(t, err)
+
+		root := plan.Tasks[len(plan.Tasks)-1]
+		assert.Equal(t, "sqrt", root.Operation)
+		assert.Equal(t, plan.Tasks[2].ID, root.Arg1Task)
+	})
+
+	t.Run("Проверка при регистрации", func(t *testing.T) {
+		self := &models.Function{Name: "f", Params: []string{"x"}, Body: "f(x)"}
+		assert.ErrorIs(t, parser.ValidateFunction(self, resolve), errors.ErrRecursiveFunction)
+
+		free := &models.Function{Name: "g", Params: []string{"x"}, Body: "x+y"}
+		assert.ErrorIs(t, parser.ValidateFunction(free, resolve), errors.ErrUnknownVariable)
+
+		builtin := &models.Function{Name: "sqrt", Params: []string{"x"}, Body: "x"}
+		assert.ErrorIs(t, parser.ValidateFunction(builtin, resolve), errors.ErrInvalidFunction)
+
+		ok := &models.Function{Name: "area", Params: []string{"a", "b"}, Body: "half(a*b)"}
+		assert.NoError(t, parser.ValidateFunction(ok, resolve))
+	})
+}
+
+func TestCompileLists(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []float64
+		tasks    int // Ожидаемое число задач; -1 — не проверяется
+		err      error
+	}{
+		{
+			name:     "Сумма",
+			input:    "sum([1,2,3,4,5,6,7,8])",
+			expected: []float64{36},
+			tasks:    7,
+		},
+		{
+			name:     "Среднее",
+			input:    "avg([2,4,9])",
+			expected: []float64{5},
+			tasks:    3,
+		},
+		{
+			name:     "Количество без задач",
+			input:    "count([1,2,3])",
+			expected: []float64{3},
+			tasks:    0,
+		},
+		{
+			name:     "Медиана одной задачей",
+			input:    "median([5,1,3,2])",
+			expected: []float64{2.5},
+			tasks:    1,
+		},
+		{
+			name:     "Стандартное отклонение",
+			input:    "stddev([2,4,4,4,5,5,7,9])",
+			expected: []float64{2},
+			tasks:    -1,
+		},
+		{
+			name:     "Аргументы агрегата объединяются",
+			input:    "sum([1,2],3)",
+			expected: []float64{6},
+			tasks:    -1,
+		},
+		{
+			name:     "Поэлементное умножение на число",
+			input:    "[1,2,3]*2",
+			expected: []float64{2, 4, 6},
+			tasks:    3,
+		},
+		{
+			name:     "Поэлементные операции над списками",
+			input:    "sqrt([9,16]+[16,9])",
+			expected: []float64{5, 5},
+			tasks:    -1,
+		},
+		{
+			name:  "Разная длина списков",
+			input: "[1,2]+[1,2,3]",
+			err:   errors.ErrListLength,
+		},
+		{
+			name:  "Среднее пустого списка",
+			input: "avg([])",
+			err:   errors.ErrEmptyList,
+		},
+		{
+			name:  "Числа вперемешку со списками",
+			input: "[[1],2]",
+			err:   errors.ErrMatrixShape,
+		},
+		{
+			name:  "Несогласованные скобки",
+			input: "[1,2)",
+			err:   errors.ErrInvalidParentheses,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{})
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.InDeltaSlice(t, tt.expected, run(plan), 1e-9)
+			if tt.tasks >= 0 {
+				assert.Equal(t, tt.tasks, len(plan.Tasks))
+			}
+		})
+	}
+}
+
+func TestCompileMatrices(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []float64
+		shape    []int
+		tasks    int
+		err      error
+	}{
+		{
+			name:     "Матрица без задач",
+			input:    "[[1,2],[3,4]]",
+			expected: []float64{1, 2, 3, 4},
+			shape:    []int{2, 2},
+			tasks:    0,
+		},
+		{
+			name:     "Транспонирование",
+			input:    "transpose([[1,2,3],[4,5,6]])",
+			expected: []float64{1, 4, 2, 5, 3, 6},
+			shape:    []int{3, 2},
+			tasks:    0,
+		},
+		{
+			name:     "Произведение одним блоком",
+			input:    "matmul([[1,2],[3,4]],[[5,6],[7,8]])",
+			expected: []float64{19, 22, 43, 50},
+			shape:    []int{2, 2},
+			tasks:    1,
+		},
+		{
+			name:     "Произведение блоками",
+			input:    "matmul([[1,2,3],[4,5,6],[7,8,9]],[[1,0,0],[0,1,0],[0,0,1]])",
+			expected: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9},
+			shape:    []int{3, 3},
+			tasks:    4, // Блоки 2×2, 2×1, 1×2 и 1×1
+		},
+		{
+			name:     "Поэлементные операции над результатом",
+			input:    "matmul([[1,2]],[[3],[4]])*2+[[1]]",
+			expected: []float64{23},
+			shape:    []int{1, 1},
+			tasks:    3,
+		},
+		{
+			name:     "Определитель",
+			input:    "det([[1,2],[3,4]])",
+			expected: []float64{-2},
+			tasks:    1,
+		},
+		{
+			name:  "Несовместимые размеры",
+			input: "matmul([[1,2]],[[1,2]])",
+			err:   errors.ErrMatrixShape,
+		},
+		{
+			name:  "Определитель неквадратной матрицы",
+			input: "det([[1,2,3],[4,5,6]])",
+			err:   errors.ErrMatrixShape,
+		},
+		{
+			name:  "Строки разной длины",
+			input: "[[1,2],[3]]",
+			err:   errors.ErrMatrixShape,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{BlockSize: 2})
+
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.shape, plan.Shape)
+			assert.Equal(t, tt.tasks, len(plan.Tasks))
+			assert.InDeltaSlice(t, tt.expected, run(plan), 1e-9)
+		})
+	}
+
+	t.Run("Обращение возвращает массив", func(t *testing.T) {
+		plan, err := parser.Compile("inv([[4,7],[2,6]])", parser.Options{})
+		assert.NoError(t, err)
+		assert.Len(t, plan.Tasks, 1)
+		assert.Equal(t, []int{2, 2}, plan.Tasks[0].Shape)
+		for i, out := range plan.Outputs {
+			assert.Equal(t, plan.Tasks[0].ID, out.Task)
+			assert.Equal(t, i, out.Index)
+		}
+	})
+}
+
+func TestCompileOptimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		level    int
+		expected float64
+		tasks    int
+	}{
+		{"Без оптимизаций", "(1+2)*(2+1)", parser.OptimizeNone, 9, 3},
+		{"Общие подвыражения с перестановкой", "(1+2)*(2+1)", parser.OptimizeSimplify, 9, 2},
+		{"Общие вызовы функций", "sqrt(2*8)+sqrt(8*2)", parser.OptimizeSimplify, 8, 3},
+		{"Умножение на единицу", "(1+2)*1", parser.OptimizeSimplify, 3, 1},
+		{"Сложение с нулём", "0+sum([1,2,3,4])-0", parser.OptimizeSimplify, 10, 3},
+		{"Деление на единицу", "(4+4)/1", parser.OptimizeSimplify, 8, 1},
+		{"Умножение литерала на ноль", "(1+2)+5*0", parser.OptimizeSimplify, 3, 1},
+		{"Умножение вычисляемого значения на ноль сохраняется", "(1+2)*0", parser.OptimizeSimplify, 0, 2},
+		{"Свёртка констант", "(1+2)*(3+4)/7", parser.OptimizeFold, 3, 0},
+		{"Деление на ноль не сворачивается", "1/(2-2)", parser.OptimizeFold, math.Inf(1), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{Optimize: tt.level})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.tasks, len(plan.Tasks))
+			assert.Equal(t, tt.expected, run(plan)[0])
+		})
+	}
+}
+
+func TestCompileRebalance(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected float64
+		depth    int // Длина критического пути в задачах
+		ordered  int // Длина критического пути с сохранением порядка
+	}{
+		{"Длинная сумма", "1+2+3+4+5+6+7+8", 36, 3, 7},
+		{"Сложение и вычитание", "1-2-3-4+5", -3, 3, 4},
+		{"Вычитание скобки", "10-(2-3)-4", 7, 2, 3},
+		{"Произведение", "2*3*4*5", 120, 2, 3},
+		{"Слагаемые разной глубины", "(1+2)*(3+4)+5+6+7", 39, 3, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, run(plan)[0])
+			assert.Equal(t, tt.depth, criticalPath(plan))
+
+			ordered, err := parser.Compile(tt.input, parser.Options{PreserveOrder: true})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, run(ordered)[0])
+			assert.Equal(t, tt.ordered, criticalPath(ordered))
+			assert.Equal(t, len(ordered.Tasks), len(plan.Tasks))
+		})
+	}
+
+	t.Run("Общие подвыражения не дублируются", func(t *testing.T) {
+		twice := func(string) (*models.Function, bool) {
+			return &models.Function{Name: "twice", Params: []string{"a"}, Body: "a+a+1"}, true
+		}
+		plan, err := parser.Compile("twice(1+2+3)", parser.Options{Functions: twice})
+		assert.NoError(t, err)
+		assert.Equal(t, 13.0, run(plan)[0])
+		assert.Len(t, plan.Tasks, 4)
+	})
+}
+
+// criticalPath возвращает наибольшее число задач, которые приходится выполнять друг за другом
+func criticalPath(plan *parser.Plan) int {
+	depth := make(map[string]int)
+	longest := 0
+	for _, task := range plan.Tasks {
+		d := 1
+		for _, dep := range task.Dependencies() {
+			d = max(d, depth[dep]+1)
+		}
+		depth[task.ID] = d
+		longest = max(longest, d)
+	}
+	return longest
+}
+
+// run выполняет задачи плана по порядку так же, как агенты, и возвращает выходы
+func run(plan *parser.Plan) []float64 {
+	results := make(map[string][]float64)
+	value := func(v float64, task string, index int) float64 {
+		if task != "" {
+			return results[task][index]
+		}
+		return v
+	}
+
+	for _, task := range plan.Tasks {
+		a := value(task.Arg1, task.Arg1Task, task.Arg1Index)
+		b := value(task.Arg2, task.Arg2Task, task.Arg2Index)
+		args := make([]float64, len(task.Args))
+		for i := range args {
+			args[i] = value(task.Args[i], task.ArgTasks[i], task.ArgIndex[i])
+		}
+
+		var result []float64
+		switch {
+		case task.Interval:
+			result = intervalOf(task.Operation, args)
+		case task.Operation == "+":
+			result = []float64{a + b}
+		case task.Operation == "-":
+			result = []float64{a - b}
+		case task.Operation == "*":
+			result = []float64{a * b}
+		case task.Operation == "/":
+			result = []float64{a / b}
+		case task.Operation == "sqrt":
+			result = []float64{math.Sqrt(a)}
+		case task.Operation == "abs":
+			result = []float64{math.Abs(a)}
+		case task.Operation == "median":
+			sort.Float64s(args)
+			mid := len(args) / 2
+			result = []float64{args[mid]}
+			if len(args)%2 == 0 {
+				result = []float64{(args[mid-1] + args[mid]) / 2}
+			}
+		case task.Operation == "matmul":
+			m, n, p := task.Shape[0], task.Shape[1], task.Shape[2]
+			result = make([]float64, m*p)
+			for i := 0; i < m; i++ {
+				for j := 0; j < p; j++ {
+					for k := 0; k < n; k++ {
+						result[i*p+j] += args[i*n+k] * args[m*n+k*p+j]
+					}
+				}
+			}
+		case task.Operation == "det":
+			result = []float64{args[0]*args[3] - args[1]*args[2]} // Только 2×2
+		}
+		results[task.ID] = result
+	}
+
+	outputs := make([]float64, len(plan.Outputs))
+	for i, out := range plan.Outputs {
+		outputs[i] = value(out.Value, out.Task, out.Index)
+	}
+	return outputs
+}
+
+// intervalOf выполняет интервальную операцию по крайним значениям границ без
+// направленного округления: для проверки структуры плана точность границ не важна
+func intervalOf(op string, args []float64) []float64 {
+	if op == "sqrt" {
+		return []float64{math.Sqrt(args[0]), math.Sqrt(args[1])}
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, a := range args[:2] {
+		for _, b := range args[2:] {
+			var v float64
+			switch op {
+			case "+":
+				v = a + b
+			case "-":
+				v = a - b
+			case "*":
+				v = a * b
+			case "/":
+				v = a / b
+			}
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	return []float64{lo, hi}
+}
+
+func TestBuildGraph(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		criticalMs  int
+		totalMs     int
+		parallelism int
+	}{
+		{"Параллельные сложения", "1+2+3+4", 2000, 3000, 2},
+		{"Последовательная цепочка", "(1+2)*3", 3000, 3000, 1},
+		{"Независимые ветви разной длины", "(1*2)+(3+4)", 3000, 4000, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{})
+			assert.NoError(t, err)
+
+			graph := parser.BuildGraph(plan.Tasks)
+			assert.Equal(t, tt.criticalMs, graph.CriticalPathMs)
+			assert.Equal(t, tt.totalMs, graph.TotalWorkMs)
+			assert.Equal(t, tt.parallelism, graph.MaxParallelism)
+			assert.Equal(t, plan.Tasks[len(plan.Tasks)-1].ID, graph.CriticalPath[len(graph.CriticalPath)-1])
+		})
+	}
+
+	t.Run("Зависимости", func(t *testing.T) {
+		plan, err := parser.Compile("(2+3)*(2+3)", parser.Options{Optimize: parser.OptimizeSimplify})
+		assert.NoError(t, err)
+
+		graph := parser.BuildGraph(plan.Tasks)
+		assert.Len(t, graph.Tasks, 2)
+		assert.Equal(t, []string{graph.Tasks[0].ID}, graph.Tasks[1].DependsOn)
+		assert.Equal(t, 1000, graph.Tasks[1].StartMs)
+	})
+}
+
+func TestGraphRender(t *testing.T) {
+	plan, err := parser.Compile("1+2+3+4", parser.Options{})
+	assert.NoError(t, err)
+	plan.Tasks[0].Status = "done"
+	plan.Tasks[0].Result = 3
+	plan.Tasks[0].AgentID = "agent-1"
+	plan.Tasks[1].Status = "cancelled"
+
+	graph := parser.BuildGraph(plan.Tasks)
+
+	dot := graph.DOT()
+	assert.True(t, strings.HasPrefix(dot, "digraph expression {"))
+	assert.Contains(t, dot, `t1 [label="t1: 1 + 2\nstatus: done\nagent: agent-1\n= 3"`)
+	assert.Contains(t, dot, `t3 [label="t3: t1 + t2\nstatus: pending"`)
+	assert.Contains(t, dot, "t1 -> t3;")
+	assert.Contains(t, dot, "t2 -> t3;")
+
+	mermaid := graph.Mermaid()
+	assert.True(t, strings.HasPrefix(mermaid, "flowchart LR\n"))
+	assert.Contains(t, mermaid, `t2["t2: 3 + 4<br/>status: cancelled"]`)
+	assert.Contains(t, mermaid, "t1 --> t3")
+	assert.Contains(t, mermaid, "class t1 done")
+	assert.Contains(t, mermaid, "class t2 cancelled")
+	assert.Contains(t, mermaid, "classDef cancelled fill:#b0bec5")
+	assert.Contains(t, dot, `status: cancelled", fillcolor="#b0bec5"`)
+
+	t.Run("ID агента с разметкой", func(t *testing.T) {
+		plan, err := parser.Compile("1+2", parser.Options{})
+		assert.NoError(t, err)
+		plan.Tasks[0].Status = "processing"
+		plan.Tasks[0].AgentID = "a\"]\n  click t1 href \"javascript:alert(1)\"\n  x[\"<script>|"
+
+		mermaid := parser.BuildGraph(plan.Tasks).Mermaid()
+		assert.Contains(t, mermaid, `agent: a#34;#93;#10;  click t1 href #34;javascript:alert#40;1#41;#34;#10;  x#91;#34;#60;script#62;#124;"]`)
+		// flowchart, узел, class и пять classDef
+		assert.Equal(t, 8, strings.Count(mermaid, "\n"), "подпись не должна добавлять строк")
+		assert.NotContains(t, mermaid, "<script>")
+	})
+
+	t.Run("Элементы результата-массива", func(t *testing.T) {
+		plan, err := parser.Compile("sum(inv([[1,2],[3,4]]))", parser.Options{})
+		assert.NoError(t, err)
+
+		dot := parser.BuildGraph(plan.Tasks).DOT()
+		assert.Contains(t, dot, "t1: inv 2×2")
+		assert.Contains(t, dot, "t1[0]")
+	})
+}
+
+func TestDerivative(t *testing.T) {
+	functions := func(name string) (*models.Function, bool) {
+		if name == "sq" {
+			return &models.Function{Name: "sq", Params: []string{"a"}, Body: "a*a"}, true
+		}
+		return nil, false
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		variable string
+		expected string
+		err      error
+	}{
+		{"Константа", "42", "x", "0", nil},
+		{"Многочлен", "x*x+3*x", "x", "2 * x + 3", nil},
+		{"Другая переменная — константа", "x*y+y", "x", "y", nil},
+		{"Частная", "1/x", "x", "0 - 1 / (x * x)", nil},
+		{"Деление на константу", "(x+1)/4", "x", "0.25", nil},
+		{"Корень", "sqrt(x)", "x", "1 / (2 * sqrt(x))", nil},
+		{"Цепное правило", "sqrt(2*x+1)", "x", "2 / (2 * sqrt(2 * x + 1))", nil},
+		{"Пользовательская функция", "sq(x)-x", "x", "2 * x - 1", nil},
+		{"Подобные слагаемые с минусом", "x-3*x", "x", "0 - 2", nil},
+		{"Отрицательный множитель", "x*(1-3*x)", "x", "1 - 6 * x", nil},
+		{"Слагаемое без переменной", "x+5/y", "x", "1", nil},
+		{"Вычитание слагаемого без переменной", "x-y/2", "x", "1", nil},
+		{"Куб", "x*x*x", "x", "3 * x * x", nil},
+		{"Четвёртая степень", "x*x*x*x", "x", "4 * x * x * x", nil},
+		{"Произведения с разным порядком множителей", "x*y*x", "x", "2 * y * x", nil},
+		{"Неподдерживаемая функция", "median(x,1)", "x", "", errors.ErrInvalidOperation},
+		{"Неизвестная функция", "f(x)", "x", "", errors.ErrFunctionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parser.Derivative(tt.input, tt.variable, parser.Options{Functions: functions})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, d.String())
+		})
+	}
+
+	t.Run("Значение в точке", func(t *testing.T) {
+		d, err := parser.Derivative("x*x*y", "x", parser.Options{})
+		assert.NoError(t, err)
+
+		plan, err := parser.Compile(d.String(), parser.Options{Variables: map[string]float64{"x": 3, "y": 2}})
+		assert.NoError(t, err)
+		assert.Equal(t, 12.0, run(plan)[0])
+
+		_, err = parser.Compile(d.String(), parser.Options{Variables: map[string]float64{"x": 3}})
+		assert.ErrorIs(t, err, errors.ErrUnknownVariable)
+	})
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		canonical string
+		latex     string
+	}{
+		{"Пробелы", "2+2*2", "2 + 2 * 2", `2 + 2 \cdot 2`},
+		{"Лишние скобки", "((2*3))+(4/5)", "2 * 3 + 4 / 5", `2 \cdot 3 + \frac{4}{5}`},
+		{"Нужные скобки", "(1+2)*(3-4)", "(1 + 2) * (3 - 4)", `\left(1 + 2\right) \cdot \left(3 - 4\right)`},
+		{"Правый операнд вычитания", "1-(2+3)-(4-5)", "1 - (2 + 3) - (4 - 5)", `1 - \left(2 + 3\right) - \left(4 - 5\right)`},
+		{"Правый операнд деления", "8/(4/2)", "8 / (4 / 2)", `\frac{8}{\frac{4}{2}}`},
+		{"Функции", "sqrt(abs(x_1))+hyp(3,4)", "sqrt(abs(x_1)) + hyp(3, 4)", `\sqrt{\left| \mathrm{x\_1} \right|} + \operatorname{hyp}\left(3, 4\right)`},
+		{"Матрица", "det([[1,2],[3,4]])", "det([[1, 2], [3, 4]])", `\operatorname{det}\left(\begin{bmatrix} 1 & 2 \\ 3 & 4 \end{bmatrix}\right)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parser.Format(tt.input, "")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.canonical, f.Canonical)
+			assert.Equal(t, tt.latex, f.LaTeX)
+
+			// Каноническая запись не меняется при повторном форматировании
+			again, err := parser.Format(f.Canonical, "")
+			assert.NoError(t, err)
+			assert.Equal(t, f.Canonical, again.Canonical)
+		})
+	}
+
+	t.Run("MathML", func(t *testing.T) {
+		f, err := parser.Format("(a-1)*2/b", "")
+		assert.NoError(t, err)
+		assert.Equal(t, `<math xmlns="http://www.w3.org/1998/Math/MathML"><mfrac><mrow><mrow><mo>(</mo><mrow><mi>a</mi><mo>&#x2212;</mo><mn>1</mn></mrow><mo>)</mo></mrow><mo>&#x22C5;</mo><mn>2</mn></mrow><mi>b</mi></mfrac></math>`, f.MathML)
+	})
+
+	t.Run("Некорректное выражение", func(t *testing.T) {
+		_, err := parser.Format("2+", "")
+		assert.ErrorIs(t, err, errors.ErrInvalidExpression)
+	})
+}
+
+func TestCompileLaTeX(t *testing.T) {
+	functions := func(name string) (*models.Function, bool) {
+		if name == "hyp" {
+			return &models.Function{Name: "hyp", Params: []string{"a", "b"}, Body: "sqrt(a*a+b*b)"}, true
+		}
+		return nil, false
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []float64
+		err      error
+	}{
+		{"Пример из документации", `\frac{3}{4} \cdot \sqrt{16} + 2^{3}`, []float64{11}, nil},
+		{"Неявное умножение", `2\left(1+2\right)3`, []float64{18}, nil},
+		{"Унарный минус", `-2^{2} + \left|-5\right|`, []float64{1}, nil},
+		{"Отрицательная степень", `2^{-2} \times 8 \div 4`, []float64{0.5}, nil},
+		{"Нулевая степень", `7^0`, []float64{1}, nil},
+		{"Большая степень", `2^{10}`, []float64{1024}, nil},
+		{"Квадратный корень с индексом", `\sqrt[2]{9}`, []float64{3}, nil},
+		{"Пользовательская функция", `\operatorname{hyp}(3, 4)`, []float64{5}, nil},
+		{"Встроенная команда", `\det\left(\begin{bmatrix} 2 & 0 \\ 0 & 3 \end{bmatrix}\right)`, []float64{6}, nil},
+		{"Список", `\operatorname{sum}([1, 2, 3])`, []float64{6}, nil},
+		{"Дробная степень", `4^{0.5}`, nil, errors.ErrInvalidExpression},
+		{"Кубический корень", `\sqrt[3]{8}`, nil, errors.ErrInvalidExpression},
+		{"Незакрытая группа", `\frac{1}{2`, nil, errors.ErrInvalidExpression},
+		{"Неизвестная команда", `\int x`, nil, errors.ErrInvalidExpression},
+		{"Несовпадающее окружение", `\begin{bmatrix} 1 \end{pmatrix}`, nil, errors.ErrInvalidExpression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{Syntax: parser.SyntaxLaTeX, Functions: functions})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDeltaSlice(t, tt.expected, run(plan), 1e-9)
+		})
+	}
+
+	t.Run("Степень возводится в квадрат", func(t *testing.T) {
+		plan, err := parser.Compile(`x^{8}`, parser.Options{Syntax: parser.SyntaxLaTeX, Variables: map[string]float64{"x": 2}})
+		assert.NoError(t, err)
+		assert.Len(t, plan.Tasks, 3)
+		assert.Equal(t, []float64{256}, run(plan))
+	})
+
+	t.Run("Разбор записи LaTeX из Format", func(t *testing.T) {
+		f, err := parser.Format("sqrt(abs(x_1)) * (2 - y) / hyp(3, 4)", "")
+		assert.NoError(t, err)
+
+		_, ast, err := parser.ParseLaTeX(f.LaTeX)
+		assert.NoError(t, err)
+		assert.Equal(t, f.Canonical, ast.String())
+	})
+
+	t.Run("Неизвестный синтаксис", func(t *testing.T) {
+		_, err := parser.Compile("1+1", parser.Options{Syntax: "asciimath"})
+		assert.ErrorIs(t, err, errors.ErrInvalidOption)
+	})
+}
+
+func TestCompileIntervals(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []float64
+		err      error
+	}{
+		{"Сумма интервалов", "[1, 2] + [3, 4]", []float64{4, 6}, nil},
+		{"Разность интервалов", "[1, 2] - [3, 4]", []float64{-3, -1}, nil},
+		{"Произведение через ноль", "[0 - 1, 2] * [3, 4]", []float64{-4, 8}, nil},
+		{"Погрешность", "2 ± 0.5 + 1", []float64{2.5, 3.5}, nil},
+		{"Число — интервал нулевой ширины", "sqrt(16) / 2", []float64{2, 2}, nil},
+		{"Интервал без операций", "[1, 2]", []float64{1, 2}, nil},
+		{"Перевёрнутые границы", "[2, 1] + 1", nil, errors.ErrInvalidExpression},
+		{"Список не интервал", "[1, 2, 3] + 1", nil, errors.ErrInvalidExpression},
+		{"Неподдерживаемая функция", "sum([1, 2, 3])", nil, errors.ErrInvalidOption},
+		{"Отрицательная погрешность", "2 ± (0 - 1)", nil, errors.ErrInvalidExpression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{Mode: parser.ModeInterval})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, plan.Interval)
+			for _, task := range plan.Tasks {
+				assert.True(t, task.Interval)
+			}
+			assert.InDeltaSlice(t, tt.expected, run(plan), 1e-9)
+		})
+	}
+
+	t.Run("Неточный литерал расширяется наружу", func(t *testing.T) {
+		plan, err := parser.Compile("0.1 + 1", parser.Options{Mode: parser.ModeInterval})
+		assert.NoError(t, err)
+		bounds := plan.Tasks[0].Args
+		assert.Less(t, bounds[0], 0.1)
+		assert.Greater(t, bounds[1], 0.1)
+		assert.Equal(t, []float64{1, 1}, bounds[2:])
+	})
+
+	t.Run("Погрешность вне режима interval", func(t *testing.T) {
+		_, err := parser.Compile("1 ± 0.1", parser.Options{})
+		assert.ErrorIs(t, err, errors.ErrInvalidOption)
+		assert.ErrorIs(t, parser.Check("2 * (1 ± 0.1)", parser.Options{}), errors.ErrInvalidOption)
+		assert.NoError(t, parser.Check("1 ± 0.1", parser.Options{Mode: parser.ModeInterval}))
+	})
+
+	t.Run("Неизвестный режим", func(t *testing.T) {
+		_, err := parser.Compile("1+1", parser.Options{Mode: "fuzzy"})
+		assert.ErrorIs(t, err, errors.ErrInvalidOption)
+	})
+}
+
+func TestCompileUnits(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected float64
+		unit     string
+		err      error
+	}{
+		{"Скорость", "5 m / 2 s", 2.5, "m/s", nil},
+		{"Сила", "3 kg * 9.81 m/s^2", 29.43, "kg*m/s^2", nil},
+		{"Приведение", "100 km/h to m/s", 100.0 / 3.6, "m/s", nil},
+		{"Сумма разных единиц длины", "1 km + 200 m to km", 1.2, "km", nil},
+		{"Энергия", "2 kW * 3 h to kWh", 6, "kWh", nil},
+		{"Корень", "sqrt(16 m^2)", 4, "m", nil},
+		{"Безразмерное отношение", "1 km / 100 m", 10, "", nil},
+		{"Агрегат", "sum([1 min, 30 s]) to s", 90, "s", nil},
+		{"Без единиц", "2 + 2", 4, "", nil},
+		{"Разные размерности", "2 m + 3 s", 0, "", errors.ErrDimensionMismatch},
+		{"Число и длина", "2 m + 3", 0, "", errors.ErrDimensionMismatch},
+		{"Несовместимое приведение", "5 m to s", 0, "", errors.ErrDimensionMismatch},
+		{"Нечётная степень под корнем", "sqrt(2 m)", 0, "", errors.ErrDimensionMismatch},
+		{"Неизвестная единица", "5 parsec", 0, "", errors.ErrUnknownUnit},
+		{"Неизвестная целевая единица", "5 m to furlong", 0, "", errors.ErrUnknownUnit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.unit, plan.Unit)
+			assert.InDeltaSlice(t, []float64{tt.expected}, run(plan), 1e-9)
+		})
+	}
+
+	t.Run("Единица числа заканчивается перед числом", func(t *testing.T) {
+		_, ast, err := parser.Syntax("5 m / 2 s")
+		assert.NoError(t, err)
+		assert.Equal(t, "/", ast.Name)
+		assert.Equal(t, "m", ast.Args[0].Unit)
+		assert.Equal(t, "s", ast.Args[1].Unit)
+		assert.Equal(t, "5 m / 2 s", ast.String())
+	})
+}
+
+// fixedRates — курсы валют к доллару для тестов
+type fixedRates map[string]*big.Rat
+
+func (r fixedRates) Rate(from, to string) (*big.Rat, error) {
+	fromRate, ok := r[from]
+	if !ok {
+		return nil, errors.ErrUnknownCurrency
+	}
+	toRate, ok := r[to]
+	if !ok {
+		return nil, errors.ErrUnknownCurrency
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+func TestCompileCurrency(t *testing.T) {
+	rates := fixedRates{
+		"USD": big.NewRat(1, 1),
+		"EUR": big.NewRat(92, 100),
+		"RUB": big.NewRat(95, 1),
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected float64
+		unit     string
+		err      error
+	}{
+		{"Пересчёт в целевую валюту", "100 USD + 46 EUR to RUB", 14250, "RUB", nil},
+		{"Валюта первой суммы", "100 USD + 46 EUR", 150, "USD", nil},
+		{"Одна валюта без курсов", "0.1 EUR + 0.2 EUR", 0.3, "EUR", nil},
+		{"Цена за единицу", "120 RUB/h * 3 h", 360, "RUB", nil},
+		{"Сумма и число", "100 USD + 5", 0, "", errors.ErrDimensionMismatch},
+		{"Неизвестная валюта", "1 USD + 1 XYZ", 0, "", errors.ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{Rates: rates})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.unit, plan.Unit)
+			for _, task := range plan.Tasks {
+				assert.True(t, task.Decimal)
+			}
+			assert.InDeltaSlice(t, []float64{tt.expected}, run(plan), 1e-9)
+		})
+	}
+
+	t.Run("Пересчёт без курсов", func(t *testing.T) {
+		_, err := parser.Compile("1 USD + 1 EUR", parser.Options{})
+		assert.ErrorIs(t, err, errors.ErrUnknownCurrency)
+	})
+
+	t.Run("Свёртка констант", func(t *testing.T) {
+		// Оркестратор не сворачивает суммы в двоичной арифметике: 0.1 + 0.2 считают агенты
+		for _, tt := range tests {
+			if tt.err != nil {
+				continue
+			}
+			plan, err := parser.Compile(tt.input, parser.Options{Rates: rates, Optimize: parser.OptimizeMax})
+			assert.NoError(t, err, tt.input)
+			assert.NotEmpty(t, plan.Tasks, tt.input)
+			for _, task := range plan.Tasks {
+				assert.True(t, task.Decimal, tt.input)
+			}
+			assert.InDeltaSlice(t, []float64{tt.expected}, run(plan), 1e-9, tt.input)
+		}
+	})
+}
+
+func TestCompileDates(t *testing.T) {
+	date := func(s string) float64 {
+		d, err := time.Parse("2006-01-02T15:04", s)
+		assert.NoError(t, err)
+		return float64(d.Unix())
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected float64
+		typ      string
+		err      error
+	}{
+		{"Дата плюс длительность", "2026-10-18 + 3d 4h", date("2026-10-21T04:00"), models.ResultDate, nil},
+		{"Длительность плюс дата", "90 min + 2026-10-18T09:30", date("2026-10-18T11:00"), models.ResultDate, nil},
+		{"Дата минус длительность", "2026-10-18 - 1 d", date("2026-10-17T00:00"), models.ResultDate, nil},
+		{"Разность дат", "2026-10-20 - 2026-10-18", 172800, models.ResultDuration, nil},
+		{"Разность дат в часах", "2026-10-20 - 2026-10-18 to h", 48, "", nil},
+		{"Составная длительность", "2 * 3d 4h", 547200, models.ResultDuration, nil},
+		{"Составная длина", "5 ft 3 in to cm", 160.02, "", nil},
+		{"Сумма дат", "2026-10-18 + 2026-10-19", 0, "", errors.ErrDimensionMismatch},
+		{"Дата плюс число", "2026-10-18 + 1", 0, "", errors.ErrDimensionMismatch},
+		{"Умножение даты", "2 * 2026-10-18", 0, "", errors.ErrDimensionMismatch},
+		{"День недели не от даты", "weekday(2 m)", 0, "", errors.ErrDimensionMismatch},
+		{"Несуществующая дата", "2026-13-45 + 1 d", 0, "", errors.ErrInvalidExpression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := parser.Compile(tt.input, parser.Options{})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.typ, plan.Type)
+			assert.InDeltaSlice(t, []float64{tt.expected}, run(plan), 1e-9)
+		})
+	}
+
+	t.Run("День недели вычисляет агент", func(t *testing.T) {
+		plan, err := parser.Compile("weekday(2026-10-18 + 1 d)", parser.Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, "", plan.Type)
+		last := plan.Tasks[len(plan.Tasks)-1]
+		assert.Equal(t, "weekday", last.Operation)
+	})
+}
@@ -0,0 +1,179 @@
+package parser
+
+import "container/heap"
+
+// rebalance перестраивает цепочки ассоциативных операций в деревья минимальной глубины:
+// 1+2+3+4 превращается в (1+2)+(3+4), и независимые сложения выполняются параллельно.
+// Цепочка сложений и вычитаний собирается как сумма положительных слагаемых минус
+// сумма отрицательных, цепочка умножений — как произведение множителей.
+// Перестановка меняет порядок операций над числами с плавающей точкой, поэтому
+// отключается флагом Options.PreserveOrder.
+func rebalance(root *Node) *Node {
+	parents := countParents(root)
+	rebalanced := make(map[*Node]*Node)
+
+	// depthOf возвращает число последовательных операций, нужных для вычисления узла
+	depths := make(map[*Node]int)
+	var depthOf func(n *Node) int
+	depthOf = func(n *Node) int {
+		if d, ok := depths[n]; ok {
+			return d
+		}
+		d := 0
+		for _, arg := range n.Args {
+			d = max(d, depthOf(arg))
+		}
+		if n.Type == NodeOperator || n.Type == NodeCall {
+			d++
+		}
+		depths[n] = d
+		return d
+	}
+
+	var visit func(n *Node) *Node
+	visit = func(n *Node) *Node {
+		if res, ok := rebalanced[n]; ok {
+			return res
+		}
+
+		var res *Node
+		switch {
+		case n.Type == NodeOperator && (n.Name == "+" || n.Name == "-"):
+			var pos, neg []*Node
+			collectTerms(n, false, parents, &pos, &neg)
+			res = combine("+", visitAll(pos, visit), depthOf)
+			if len(neg) > 0 {
+				res = &Node{Type: NodeOperator, Name: "-", Args: []*Node{res, combine("+", visitAll(neg, visit), depthOf)}}
+			}
+		case n.Type == NodeOperator && n.Name == "*":
+			var factors []*Node
+			collectFactors(n, parents, &factors)
+			res = combine("*", visitAll(factors, visit), depthOf)
+		case len(n.Args) > 0:
+			res = &Node{Type: n.Type, Value: n.Value, Name: n.Name, Args: visitAll(n.Args, visit), Index: n.Index, Shape: n.Shape}
+		default:
+			res = n
+		}
+
+		rebalanced[n] = res
+		return res
+	}
+
+	return visit(root)
+}
+
+// collectTerms раскладывает цепочку + и - на слагаемые со знаком.
+// Узлы с несколькими родителями не раскладываются, чтобы не вычислять их дважды.
+func collectTerms(n *Node, negative bool, parents map[*Node]int, pos, neg *[]*Node) {
+	for i, arg := range n.Args {
+		argNegative := negative != (i == 1 && n.Name == "-")
+		if isChain(arg, "+", "-") && parents[arg] == 1 {
+			collectTerms(arg, argNegative, parents, pos, neg)
+			continue
+		}
+		if argNegative {
+			*neg = append(*neg, arg)
+		} else {
+			*pos = append(*pos, arg)
+		}
+	}
+}
+
+// collectFactors раскладывает цепочку умножений на множители
+func collectFactors(n *Node, parents map[*Node]int, factors *[]*Node) {
+	for _, arg := range n.Args {
+		if isChain(arg, "*") && parents[arg] == 1 {
+			collectFactors(arg, parents, factors)
+			continue
+		}
+		*factors = append(*factors, arg)
+	}
+}
+
+func isChain(n *Node, ops ...string) bool {
+	if n.Type != NodeOperator {
+		return false
+	}
+	for _, op := range ops {
+		if n.Name == op {
+			return true
+		}
+	}
+	return false
+}
+
+func visitAll(nodes []*Node, visit func(*Node) *Node) []*Node {
+	res := make([]*Node, len(nodes))
+	for i, n := range nodes {
+		res[i] = visit(n)
+	}
+	return res
+}
+
+// countParents считает, сколько узлов ссылается на каждый узел дерева
+func countParents(root *Node) map[*Node]int {
+	parents := map[*Node]int{root: 1}
+	seen := make(map[*Node]bool)
+
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		for _, arg := range n.Args {
+			parents[arg]++
+			walk(arg)
+		}
+	}
+	walk(root)
+	return parents
+}
+
+// combine объединяет операнды, каждый раз соединяя два самых неглубоких,
+// так что глубина результата минимальна и при операндах разной глубины
+func combine(op string, items []*Node, depthOf func(*Node) int) *Node {
+	h := make(depthHeap, len(items))
+	for i, item := range items {
+		h[i] = depthItem{node: item, depth: depthOf(item), order: i}
+	}
+	heap.Init(&h)
+
+	order := len(items)
+	for h.Len() > 1 {
+		a := heap.Pop(&h).(depthItem)
+		b := heap.Pop(&h).(depthItem)
+		// Исходный порядок операндов внутри пары сохраняется
+		if b.order < a.order {
+			a, b = b, a
+		}
+		node := &Node{Type: NodeOperator, Name: op, Args: []*Node{a.node, b.node}}
+		heap.Push(&h, depthItem{node: node, depth: max(a.depth, b.depth) + 1, order: order})
+		order++
+	}
+	return h[0].node
+}
+
+type depthItem struct {
+	node  *Node
+	depth int
+	order int // Порядок появления: при равной глубине первыми соединяются более ранние
+}
+
+type depthHeap []depthItem
+
+func (h depthHeap) Len() int { return len(h) }
+func (h depthHeap) Less(i, j int) bool {
+	if h[i].depth != h[j].depth {
+		return h[i].depth < h[j].depth
+	}
+	return h[i].order < h[j].order
+}
+func (h depthHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *depthHeap) Push(x any)   { *h = append(*h, x.(depthItem)) }
+func (h *depthHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
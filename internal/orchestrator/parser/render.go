@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"calc_service/pkg/models"
+)
+
+// Цвета узлов по статусу задачи
+var statusColors = map[string]string{
+	"pending":    "#e0e0e0",
+	"processing": "#ffe082",
+	"done":       "#c8e6c9",
+	"error":      "#ffcdd2",
+	"cancelled":  "#b0bec5",
+}
+
+// DOT выводит граф задач в формате Graphviz
+func (g *Graph) DOT() string {
+	names := g.nodeNames()
+	var b strings.Builder
+
+	b.WriteString("digraph expression {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	for _, task := range g.Tasks {
+		label := strings.Join(g.label(task, names), "\n")
+		fmt.Fprintf(&b, "  %s [label=%s, fillcolor=%q];\n", names[task.ID], dotQuote(label), statusColor(task.Status))
+	}
+	for _, task := range g.Tasks {
+		for _, dep := range task.DependsOn {
+			fmt.Fprintf(&b, "  %s -> %s;\n", names[dep], names[task.ID])
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid выводит граф задач в формате Mermaid flowchart
+func (g *Graph) Mermaid() string {
+	names := g.nodeNames()
+	var b strings.Builder
+
+	b.WriteString("flowchart LR\n")
+	for _, task := range g.Tasks {
+		lines := g.label(task, names)
+		for i, line := range lines {
+			lines[i] = mermaidEscape(line)
+		}
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", names[task.ID], strings.Join(lines, "<br/>"))
+	}
+	for _, task := range g.Tasks {
+		for _, dep := range task.DependsOn {
+			fmt.Fprintf(&b, "  %s --> %s\n", names[dep], names[task.ID])
+		}
+	}
+	for _, task := range g.Tasks {
+		fmt.Fprintf(&b, "  class %s %s\n", names[task.ID], statusClass(task.Status))
+	}
+	for _, status := range []string{"pending", "processing", "done", "error", "cancelled"} {
+		fmt.Fprintf(&b, "  classDef %s fill:%s\n", status, statusColors[status])
+	}
+	return b.String()
+}
+
+// nodeNames даёт задачам короткие имена t1, t2, … в порядке графа
+func (g *Graph) nodeNames() map[string]string {
+	names := make(map[string]string, len(g.Tasks))
+	for i, task := range g.Tasks {
+		names[task.ID] = "t" + strconv.Itoa(i+1)
+	}
+	return names
+}
+
+// label составляет подпись узла: операция с операндами, статус, агент и результат
+func (g *Graph) label(task GraphTask, names map[string]string) []string {
+	lines := []string{names[task.ID] + ": " + g.describe(task.Task, names)}
+
+	status := task.Status
+	if status == "" {
+		status = "pending"
+	}
+	lines = append(lines, "status: "+status)
+	if task.AgentID != "" {
+		lines = append(lines, "agent: "+task.AgentID)
+	}
+	if task.Status == "done" && task.Results == nil {
+		lines = append(lines, "= "+formatNumber(task.Result))
+	}
+	return lines
+}
+
+// describe записывает операцию задачи с операндами; операнд из другой задачи
+// обозначается её именем, элемент результата-массива — именем с индексом
+func (g *Graph) describe(task *models.Task, names map[string]string) string {
+	operand := func(value float64, dep string, index int) string {
+		if dep == "" {
+			return formatNumber(value)
+		}
+		for _, t := range g.Tasks {
+			if t.ID == dep && t.ReturnsArray() {
+				return fmt.Sprintf("%s[%d]", names[dep], index)
+			}
+		}
+		return names[dep]
+	}
+
+	switch {
+	case task.Interval:
+		return describeInterval(task, names)
+	case task.Operation == "matmul" && len(task.Shape) == 3:
+		return fmt.Sprintf("matmul %d×%d · %d×%d", task.Shape[0], task.Shape[1], task.Shape[1], task.Shape[2])
+	case len(task.Shape) == 2:
+		return fmt.Sprintf("%s %d×%d", task.Operation, task.Shape[0], task.Shape[1])
+	case models.IsListOperation(task.Operation):
+		return fmt.Sprintf("%s, n=%d", task.Operation, len(task.Args))
+	case precedence(task.Operation) > 0:
+		a := operand(task.Arg1, task.Arg1Task, task.Arg1Index)
+		b := operand(task.Arg2, task.Arg2Task, task.Arg2Index)
+		return fmt.Sprintf("%s %s %s", a, task.Operation, b)
+	}
+	return fmt.Sprintf("%s(%s)", task.Operation, operand(task.Arg1, task.Arg1Task, task.Arg1Index))
+}
+
+// describeInterval записывает интервальную операцию: операнд — имя задачи,
+// вычисляющей обе границы, или интервал-литерал [нижняя, верхняя]
+func describeInterval(task *models.Task, names map[string]string) string {
+	var operands []string
+	for i := 0; i+1 < len(task.Args); i += 2 {
+		dep := ""
+		if i < len(task.ArgTasks) {
+			dep = task.ArgTasks[i]
+		}
+		if dep != "" {
+			operands = append(operands, names[dep])
+			continue
+		}
+		operands = append(operands, fmt.Sprintf("[%s, %s]", formatNumber(task.Args[i]), formatNumber(task.Args[i+1])))
+	}
+	if len(operands) == 2 {
+		return fmt.Sprintf("%s %s %s", operands[0], task.Operation, operands[1])
+	}
+	return fmt.Sprintf("%s(%s)", task.Operation, strings.Join(operands, ", "))
+}
+
+// formatNumber записывает число без экспоненты, чтобы запись можно было снова разобрать
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func statusClass(status string) string {
+	if _, ok := statusColors[status]; ok {
+		return status
+	}
+	return "pending"
+}
+
+func statusColor(status string) string {
+	return statusColors[statusClass(status)]
+}
+
+// dotQuote заключает строку в кавычки DOT; перевод строки становится \n
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// mermaidEscape заменяет в строке подписи Mermaid кодом #NNN; каждый символ, кроме букв,
+// цифр, пробела и знаков операций. Подпись приходит в том числе от клиента (ID агента),
+// поэтому кавычки, скобки, |, <, >, переводы строк и прочее не должны менять разметку
+func mermaidEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" .,:=+-*/^%_×·±", r) {
+			b.WriteRune(r)
+			continue
+		}
+		fmt.Fprintf(&b, "#%d;", r)
+	}
+	return b.String()
+}
@@ -0,0 +1,542 @@
+package parser
+
+import (
+	stderrors "errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"calc_service/internal/orchestrator/rates"
+	"calc_service/pkg/errors"
+)
+
+// NodeUnit — единица измерения, к которой приводится результат: правый операнд to
+const NodeUnit = "unit"
+
+// dimension — степени основных единиц СИ в порядке baseUnits, степень денег
+// и признак даты (instant)
+type dimension [9]int
+
+// money — размерность денежных сумм: суммы в разных валютах приводятся к одной валюте
+const money = 7
+
+// Основные единицы СИ в том порядке, в котором они записываются в размерности
+var baseUnits = [7]string{"kg", "m", "s", "A", "K", "mol", "cd"}
+
+// unit — единица измерения: множитель перевода в СИ и размерность.
+// Денежная единица дополнительно пересчитывается по курсу валюты currency
+type unit struct {
+	factor   float64
+	dim      dimension
+	currency string
+}
+
+func base(i int) dimension {
+	var d dimension
+	d[i] = 1
+	return d
+}
+
+// units — известные единицы: множитель перевода в СИ и размерность;
+// для prefixed дополнительно допускаются десятичные приставки
+var units = map[string]struct {
+	factor float64
+	dim    dimension
+}{
+	"m":   {1, base(1)},
+	"g":   {1e-3, base(0)},
+	"s":   {1, base(2)},
+	"A":   {1, base(3)},
+	"K":   {1, base(4)},
+	"mol": {1, base(5)},
+	"cd":  {1, base(6)},
+
+	"in":  {0.0254, base(1)},
+	"ft":  {0.3048, base(1)},
+	"yd":  {0.9144, base(1)},
+	"mi":  {1609.344, base(1)},
+	"t":   {1000, base(0)},
+	"lb":  {0.45359237, base(0)},
+	"min": {60, base(2)},
+	"h":   {3600, base(2)},
+	"d":   {86400, base(2)},
+
+	"Hz":  {1, dimension{0, 0, -1}},
+	"N":   {1, dimension{1, 1, -2}},
+	"Pa":  {1, dimension{1, -1, -2}},
+	"J":   {1, dimension{1, 2, -2}},
+	"W":   {1, dimension{1, 2, -3}},
+	"Wh":  {3600, dimension{1, 2, -2}},
+	"C":   {1, dimension{0, 0, 1, 1}},
+	"V":   {1, dimension{1, 2, -3, -1}},
+	"Ohm": {1, dimension{1, 2, -3, -2}},
+	"L":   {1e-3, dimension{0, 3}},
+}
+
+// Единицы, к которым применимы приставки, и сами приставки (u — микро)
+var (
+	prefixed = map[string]bool{
+		"m": true, "g": true, "s": true, "A": true, "mol": true,
+		"Hz": true, "N": true, "Pa": true, "J": true, "W": true, "Wh": true,
+		"C": true, "V": true, "Ohm": true, "L": true,
+	}
+	prefixes = map[string]float64{
+		"G": 1e9, "M": 1e6, "k": 1e3, "c": 1e-2, "m": 1e-3, "u": 1e-6, "n": 1e-9,
+	}
+)
+
+// lookupUnit ищет единицу по обозначению, в том числе с приставкой: km, ms, kWh.
+// Три заглавные буквы — код валюты ISO 4217: USD, EUR
+func lookupUnit(symbol string) (unit, bool) {
+	if isCurrency(symbol) {
+		var d dimension
+		d[money] = 1
+		return unit{factor: 1, dim: d, currency: symbol}, true
+	}
+	if u, ok := units[symbol]; ok {
+		return unit{factor: u.factor, dim: u.dim}, true
+	}
+	for p, factor := range prefixes {
+		name, ok := strings.CutPrefix(symbol, p)
+		if ok && prefixed[name] {
+			u := units[name]
+			return unit{factor: u.factor * factor, dim: u.dim}, true
+		}
+	}
+	return unit{}, false
+}
+
+// parseUnit разбирает запись единицы: обозначения через * и / со степенями, m/s^2 или kg*m^2/s^2.
+// Операции выполняются слева направо, как в выражении
+func parseUnit(s string) (unit, error) {
+	res := unit{factor: 1}
+	op := byte('*')
+	for i := 0; i < len(s); {
+		start := i
+		for i < len(s) && isLatin(s[i]) {
+			i++
+		}
+		symbol := s[start:i]
+		if symbol == "" {
+			return unit{}, fmt.Errorf("%w: запись единицы %q", errors.ErrInvalidExpression, s)
+		}
+		u, ok := lookupUnit(symbol)
+		if !ok {
+			return unit{}, fmt.Errorf("%w: %q", errors.ErrUnknownUnit, symbol)
+		}
+
+		power := 1
+		if i < len(s) && s[i] == '^' {
+			start = i + 1
+			i = start
+			if i < len(s) && s[i] == '-' {
+				i++
+			}
+			for i < len(s) && unicode.IsDigit(rune(s[i])) {
+				i++
+			}
+			var err error
+			if power, err = strconv.Atoi(s[start:i]); err != nil {
+				return unit{}, fmt.Errorf("%w: степень единицы %s", errors.ErrInvalidExpression, symbol)
+			}
+		}
+		if op == '/' {
+			power = -power
+		}
+		if u.currency != "" {
+			if res.currency != "" && res.currency != u.currency {
+				return unit{}, fmt.Errorf("%w: в единице %s несколько валют", errors.ErrDimensionMismatch, s)
+			}
+			res.currency = u.currency
+		}
+		res.factor *= math.Pow(u.factor, float64(power))
+		for k := range res.dim {
+			res.dim[k] += u.dim[k] * power
+		}
+
+		if i == len(s) {
+			return res, nil
+		}
+		if s[i] != '*' && s[i] != '/' {
+			return unit{}, fmt.Errorf("%w: запись единицы %q", errors.ErrInvalidExpression, s)
+		}
+		op = s[i]
+		i++
+	}
+	return unit{}, fmt.Errorf("%w: запись единицы %q", errors.ErrInvalidExpression, s)
+}
+
+// readUnit считывает единицу, записанную сразу за числом. Запись продолжается через * и /,
+// пока за знаком следует известная единица, поэтому в 5 m / 2 s единица числа 5 — только m
+func readUnit(expr string, i *int) (string, error) {
+	start := *i
+	for {
+		symbol := readSymbol(expr, i)
+		if _, ok := lookupUnit(symbol); !ok {
+			return "", fmt.Errorf("%w: %q", errors.ErrUnknownUnit, symbol)
+		}
+		if *i < len(expr) && expr[*i] == '^' {
+			*i++
+			if *i < len(expr) && expr[*i] == '-' {
+				*i++
+			}
+			for *i < len(expr) && unicode.IsDigit(rune(expr[*i])) {
+				*i++
+			}
+		}
+
+		// За знаком операции должна стоять единица, а не число, переменная или вызов функции
+		if *i+1 >= len(expr) || (expr[*i] != '*' && expr[*i] != '/') {
+			break
+		}
+		next := *i + 1
+		symbol = readSymbol(expr, &next)
+		if _, ok := lookupUnit(symbol); !ok || next < len(expr) && expr[next] == '(' {
+			break
+		}
+		*i++
+	}
+	unitStr := expr[start:*i]
+	*i-- // Возвращаем индекс на последний символ единицы
+	return unitStr, nil
+}
+
+// readSymbol считывает обозначение единицы и оставляет индекс за ним
+func readSymbol(expr string, i *int) string {
+	start := *i
+	for *i < len(expr) && isLatin(expr[*i]) {
+		*i++
+	}
+	return expr[start:*i]
+}
+
+func isCurrency(symbol string) bool {
+	if len(symbol) != 3 {
+		return false
+	}
+	for i := 0; i < len(symbol); i++ {
+		if symbol[i] < 'A' || symbol[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// isLatin сообщает, что байт — латинская буква: обозначения единиц записываются латиницей
+func isLatin(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// quantityToken кодирует число с единицей одним токеном: "5 km"
+func quantityToken(number, unit string) string {
+	return number + " " + unit
+}
+
+func isQuantity(token string) bool {
+	number, _, ok := strings.Cut(token, " ")
+	return ok && isNumber(number)
+}
+
+// Преобразование в конце выражения: 100 km/h to m/s
+var conversion = regexp.MustCompile(`^(.*\S)\s+to\s+(\S.*)$`)
+
+// splitConversion отделяет от выражения единицу, к которой нужно привести результат
+func splitConversion(expr string) (string, string) {
+	m := conversion.FindStringSubmatch(expr)
+	if m == nil {
+		return expr, ""
+	}
+	return m[1], strings.ReplaceAll(m[2], " ", "")
+}
+
+// String записывает размерность через основные единицы СИ: kg*m/s^2; безразмерная — пустая строка
+func (d dimension) String() string {
+	return d.format("деньги")
+}
+
+// format записывает размерность, обозначая деньги кодом валюты currency
+func (d dimension) format(currency string) string {
+	var num, den []string
+	for i, p := range d[:instant] {
+		symbol := currency
+		if i < len(baseUnits) {
+			symbol = baseUnits[i]
+		}
+		switch {
+		case p == 1:
+			num = append(num, symbol)
+		case p > 1:
+			num = append(num, fmt.Sprintf("%s^%d", symbol, p))
+		case p == -1:
+			den = append(den, symbol)
+		case p < -1:
+			den = append(den, fmt.Sprintf("%s^%d", symbol, -p))
+		}
+	}
+	if len(num) == 0 && len(den) == 0 {
+		return ""
+	}
+	res := strings.Join(num, "*")
+	if res == "" {
+		res = "1"
+	}
+	for _, symbol := range den {
+		res += "/" + symbol
+	}
+	return res
+}
+
+// describe называет размерность в сообщении об ошибке
+func (d dimension) describe() string {
+	if d[instant] != 0 {
+		return "дата"
+	}
+	if s := d.String(); s != "" {
+		return s
+	}
+	return "безразмерная"
+}
+
+// lowerUnits проверяет размерности выражения, переводит числа с единицами в СИ
+// и заменяет приведение to делением на множитель единицы. Денежные суммы
+// пересчитываются по курсам в одну валюту: ту, к которой приводится результат,
+// или первую встреченную. Возвращает дерево без единиц и единицу результата:
+// запись из to или размерность в единицах СИ.
+func lowerUnits(root *Node, provider rates.RateProvider) (*Node, dimension, string, error) {
+	if !hasUnits(root) {
+		return root, dimension{}, "", nil
+	}
+
+	conv := converter{rates: provider}
+	if root.Type == NodeOperator && root.Name == "to" {
+		target, err := parseUnit(root.Args[1].Name)
+		if err != nil {
+			return nil, dimension{}, "", err
+		}
+		conv.currency = target.currency
+	}
+	if conv.currency == "" {
+		conv.currency = firstCurrency(root)
+	}
+
+	type lowered struct {
+		node *Node
+		dim  dimension
+	}
+	done := make(map[*Node]lowered)
+
+	var lower func(n *Node) (lowered, error)
+	lower = func(n *Node) (lowered, error) {
+		if res, ok := done[n]; ok {
+			return res, nil
+		}
+
+		var res lowered
+		switch {
+		case n.Type == NodeNumber && n.Unit != "":
+			u, err := parseUnit(n.Unit)
+			if err != nil {
+				return lowered{}, err
+			}
+			value, err := conv.convert(n.Value, u)
+			if err != nil {
+				return lowered{}, err
+			}
+			res = lowered{number(value), u.dim}
+		case n.Type == NodeDate:
+			res = lowered{number(n.Value), dateDimension}
+		case len(n.Args) == 0:
+			res = lowered{node: n}
+		default:
+			args := make([]*Node, len(n.Args))
+			dims := make([]dimension, len(n.Args))
+			for i, arg := range n.Args {
+				a, err := lower(arg)
+				if err != nil {
+					return lowered{}, err
+				}
+				args[i], dims[i] = a.node, a.dim
+			}
+			dim, err := resultDimension(n, dims)
+			if err != nil {
+				return lowered{}, err
+			}
+			res = lowered{&Node{Type: n.Type, Value: n.Value, Name: n.Name, Args: args}, dim}
+		}
+
+		done[n] = res
+		return res, nil
+	}
+
+	if root.Type == NodeOperator && root.Name == "to" {
+		value, err := lower(root.Args[0])
+		if err != nil {
+			return nil, dimension{}, "", err
+		}
+		target, err := parseUnit(root.Args[1].Name)
+		if err != nil {
+			return nil, dimension{}, "", err
+		}
+		if value.dim != target.dim {
+			return nil, dimension{}, "", fmt.Errorf("%w: %s нельзя привести к %s", errors.ErrDimensionMismatch, value.dim.describe(), root.Args[1].Name)
+		}
+		factor, err := conv.convert(1, target)
+		if err != nil {
+			return nil, dimension{}, "", err
+		}
+		tree := value.node
+		if factor != 1 {
+			tree = operator("/", tree, number(factor))
+		}
+		return tree, target.dim, root.Args[1].Name, nil
+	}
+
+	res, err := lower(root)
+	if err != nil {
+		return nil, dimension{}, "", err
+	}
+	return res.node, res.dim, res.dim.format(conv.currency), nil
+}
+
+// converter переводит величины в СИ, а деньги — в валюту currency
+type converter struct {
+	rates    rates.RateProvider
+	currency string
+}
+
+// convert переводит значение в единице u. Вычисление ведётся в десятичных дробях:
+// 0.1 km превращается ровно в 100 m, а сумма по курсу 1.07 — ровно в произведение
+func (c converter) convert(value float64, u unit) (float64, error) {
+	res := decimal(value)
+	res.Mul(res, decimal(u.factor))
+
+	if power := u.dim[money]; power != 0 && u.currency != c.currency {
+		if c.rates == nil {
+			return 0, fmt.Errorf("%w: курсы валют не настроены, %s нельзя перевести в %s", errors.ErrUnknownCurrency, u.currency, c.currency)
+		}
+		rate, err := c.rates.Rate(u.currency, c.currency)
+		if err != nil {
+			return 0, err
+		}
+		for ; power > 0; power-- {
+			res.Mul(res, rate)
+		}
+		for ; power < 0; power++ {
+			res.Quo(res, rate)
+		}
+	}
+
+	f, _ := res.Float64()
+	return f, nil
+}
+
+// decimal возвращает десятичную дробь, которую записывает кратчайшая запись числа
+func decimal(v float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(formatNumber(v))
+	if !ok {
+		return new(big.Rat).SetFloat64(v)
+	}
+	return r
+}
+
+// firstCurrency возвращает первую валюту в записи выражения
+func firstCurrency(n *Node) string {
+	if n.Unit != "" {
+		if u, err := parseUnit(n.Unit); err == nil && u.currency != "" {
+			return u.currency
+		}
+	}
+	for _, arg := range n.Args {
+		if currency := firstCurrency(arg); currency != "" {
+			return currency
+		}
+	}
+	return ""
+}
+
+// usesCurrency сообщает, есть ли в выражении денежные суммы
+func usesCurrency(n *Node) bool {
+	return firstCurrency(n) != ""
+}
+
+// resultDimension вычисляет размерность результата операции по размерностям операндов
+func resultDimension(n *Node, dims []dimension) (dimension, error) {
+	same := func(what string) (dimension, error) {
+		for _, d := range dims[1:] {
+			if d != dims[0] {
+				return dimension{}, fmt.Errorf("%w: %s: %s и %s", errors.ErrDimensionMismatch, what, dims[0].describe(), d.describe())
+			}
+		}
+		return dims[0], nil
+	}
+	dimensionless := func() (dimension, error) {
+		for _, d := range dims {
+			if d != (dimension{}) {
+				return dimension{}, fmt.Errorf("%w: %s ожидает безразмерные значения, получено %s", errors.ErrDimensionMismatch, n.Name, d.describe())
+			}
+		}
+		return dimension{}, nil
+	}
+	if d, ok, err := dateDimensionOf(n, dims); ok {
+		return d, err
+	}
+
+	switch {
+	case n.Type == NodeList:
+		return same("элементы списка")
+	case n.Type == NodeOperator && n.Name == "to":
+		return dimension{}, fmt.Errorf("%w: to допускается только в конце выражения", errors.ErrInvalidExpression)
+	case n.Type == NodeOperator && n.Name == "*":
+		var d dimension
+		for k := range d {
+			d[k] = dims[0][k] + dims[1][k]
+		}
+		return d, nil
+	case n.Type == NodeOperator && n.Name == "/":
+		var d dimension
+		for k := range d {
+			d[k] = dims[0][k] - dims[1][k]
+		}
+		return d, nil
+	case n.Type == NodeOperator:
+		return same(n.Name)
+	}
+
+	switch n.Name {
+	case "abs", "sum", "avg", "median", "stddev", "transpose":
+		return same(n.Name)
+	case "count":
+		return dimension{}, nil
+	case "sqrt":
+		var d dimension
+		for k, p := range dims[0] {
+			if p%2 != 0 {
+				return dimension{}, fmt.Errorf("%w: корень из %s", errors.ErrDimensionMismatch, dims[0].describe())
+			}
+			d[k] = p / 2
+		}
+		return d, nil
+	}
+	return dimensionless()
+}
+
+func hasUnits(n *Node) bool {
+	if n.Unit != "" || n.Type == NodeUnit || n.Type == NodeDate || n.Type == NodeOperator && n.Name == "to" {
+		return true
+	}
+	for _, arg := range n.Args {
+		if hasUnits(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+func isUnitError(err error) bool {
+	return stderrors.Is(err, errors.ErrUnknownUnit) || stderrors.Is(err, errors.ErrDimensionMismatch) ||
+		stderrors.Is(err, errors.ErrUnknownCurrency)
+}
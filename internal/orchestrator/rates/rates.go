@@ -0,0 +1,91 @@
+// Package rates предоставляет курсы валют для пересчёта денежных сумм в выражениях
+package rates
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	"calc_service/pkg/errors"
+)
+
+// RateProvider возвращает курс обмена: сколько единиц валюты to стоит одна единица from.
+// Курс — точная десятичная дробь, чтобы пересчёт не накапливал ошибку округления
+type RateProvider interface {
+	Rate(from, to string) (*big.Rat, error)
+}
+
+// FileProvider читает курсы из JSON-файла и работает без доступа к сети:
+//
+//	{"base": "USD", "rates": {"EUR": "0.92", "RUB": "95.5"}}
+//
+// Курс в rates — цена одной единицы base в этой валюте; числа можно записывать строками,
+// чтобы они не проходили через float64
+type FileProvider struct {
+	path string
+
+	mu    sync.RWMutex
+	base  string
+	rates map[string]*big.Rat
+}
+
+type ratesFile struct {
+	Base  string                 `json:"base"`
+	Rates map[string]json.Number `json:"rates"`
+}
+
+// NewFileProvider загружает курсы из файла
+func NewFileProvider(path string) (*FileProvider, error) {
+	p := &FileProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload перечитывает файл курсов; при ошибке остаются прежние курсы
+func (p *FileProvider) Reload() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("чтение курсов валют: %w", err)
+	}
+	var file ratesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%w: курсы валют: %v", errors.ErrInvalidJSON, err)
+	}
+	if file.Base == "" {
+		return fmt.Errorf("%w: в файле курсов не указана базовая валюта", errors.ErrInvalidJSON)
+	}
+
+	rates := map[string]*big.Rat{file.Base: big.NewRat(1, 1)}
+	for code, value := range file.Rates {
+		rate, ok := new(big.Rat).SetString(string(value))
+		if !ok || rate.Sign() <= 0 {
+			return fmt.Errorf("%w: курс %s: %s", errors.ErrInvalidJSON, code, value)
+		}
+		rates[code] = rate
+	}
+
+	p.mu.Lock()
+	p.base, p.rates = file.Base, rates
+	p.mu.Unlock()
+	return nil
+}
+
+// Rate пересчитывает курс через базовую валюту файла
+func (p *FileProvider) Rate(from, to string) (*big.Rat, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	fromRate, ok := p.rates[from]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errors.ErrUnknownCurrency, from)
+	}
+	toRate, ok := p.rates[to]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errors.ErrUnknownCurrency, to)
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
@@ -0,0 +1,53 @@
+package rates_test
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"calc_service/internal/orchestrator/rates"
+	"calc_service/pkg/errors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	write(`{"base": "USD", "rates": {"EUR": "0.8", "RUB": 90}}`)
+
+	provider, err := rates.NewFileProvider(path)
+	assert.NoError(t, err)
+
+	t.Run("Курс через базовую валюту", func(t *testing.T) {
+		rate, err := provider.Rate("EUR", "RUB")
+		assert.NoError(t, err)
+		assert.Equal(t, big.NewRat(225, 2), rate) // 90 / 0.8
+
+		rate, err = provider.Rate("USD", "EUR")
+		assert.NoError(t, err)
+		assert.Equal(t, big.NewRat(4, 5), rate)
+	})
+
+	t.Run("Неизвестная валюта", func(t *testing.T) {
+		_, err := provider.Rate("USD", "GBP")
+		assert.ErrorIs(t, err, errors.ErrUnknownCurrency)
+	})
+
+	t.Run("Перечитывание файла", func(t *testing.T) {
+		write(`{"base": "USD", "rates": {"GBP": "0.75"}}`)
+		assert.NoError(t, provider.Reload())
+		rate, err := provider.Rate("USD", "GBP")
+		assert.NoError(t, err)
+		assert.Equal(t, big.NewRat(3, 4), rate)
+
+		// Некорректный файл не затирает загруженные курсы
+		write(`{"base": "USD", "rates": {"GBP": "-1"}}`)
+		assert.ErrorIs(t, provider.Reload(), errors.ErrInvalidJSON)
+		_, err = provider.Rate("USD", "GBP")
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"calc_service/pkg/models"
+	"time"
+)
+
+// SetIdempotencyWindow задаёт, сколько помнить ключ идемпотентности: повтор запроса
+// с тем же ключом в течение этого времени не создаёт новое выражение
+func (s *MemoryStorage) SetIdempotencyWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idempotencyTTL = window
+}
+
+// idempotencyScope — ключ идемпотентности в пространстве ключей своего клиента
+type idempotencyScope struct {
+	tenant, key string
+}
+
+// AddExpressionWithKey сохраняет выражение вместе с ключом, если ключа ещё нет или срок
+// прежнего истёк, и возвращает (key, true). Иначе выражение не сохраняется, а возвращается
+// запись, сохранённая первым запросом, и false. Ключ и выражение сохраняются под одной
+// блокировкой, поэтому ключ никогда не указывает на ещё не сохранённое выражение
+func (s *MemoryStorage) AddExpressionWithKey(expr *models.Expression, key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		for k, existing := range s.idempotency {
+			if now.Sub(existing.CreatedAt) >= s.idempotencyTTL {
+				delete(s.idempotency, k)
+			}
+		}
+		s.nextSweep = now.Add(s.idempotencyTTL)
+	}
+
+	scope := idempotencyScope{key.Tenant, key.Key}
+	if existing, ok := s.idempotency[scope]; ok && now.Sub(existing.CreatedAt) < s.idempotencyTTL {
+		return existing, false, nil
+	}
+	if err := s.addExpression(expr); err != nil {
+		return nil, false, err
+	}
+	s.idempotency[scope] = key
+	return key, true, nil
+}
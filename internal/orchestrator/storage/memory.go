@@ -3,27 +3,70 @@ package storage
 import (
 	"calc_service/pkg/errors"
 	"calc_service/pkg/models"
+	"sort"
 	"sync"
+	"time"
 )
 
+// DefaultTaskTimeout — сколько сверх времени операции ждать результата от агента,
+// прежде чем выдать задачу повторно
+const DefaultTaskTimeout = 30 * time.Second
+
+// DefaultIdempotencyWindow — сколько помнить ключ идемпотентности
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// Storage хранит выражения и задачи. Методы принимают и возвращают копии: изменить
+// сохранённое выражение или задачу можно только через методы хранилища
 type Storage interface {
 	AddExpression(*models.Expression) error
 	GetExpression(string) (*models.Expression, bool)
 	GetAllExpressions() ([]*models.Expression, error)
+	QueryExpressions(ExpressionQuery) (*ExpressionPage, error)
 	UpdateExpression(*models.Expression) error
+	CancelExpression(string) error
+	DeleteExpression(string) error
+	ExpressionDone(string) (<-chan struct{}, error)
 	AddTask(*models.Task) error
+	AddTasks(string, []*models.Task) error
 	GetNextTask() (*models.Task, error)
+	GetNextTaskForAgent(string) (*models.Task, error)
 	CompleteTask(string, float64) error
+	CompleteArrayTask(string, []float64) error
+	FailTask(string, string) error
 	GetTask(string) (*models.Task, bool)
+	GetExpressionTasks(string) ([]*models.Task, error)
 	UpdateTask(*models.Task) error
+	AddFunction(*models.Function) error
+	GetFunction(string) (*models.Function, bool)
+	GetAllFunctions() ([]*models.Function, error)
+	AddBatch(*models.Batch) error
+	GetBatch(string) (*models.Batch, bool)
+	AddExpressionWithKey(*models.Expression, *models.IdempotencyKey) (*models.IdempotencyKey, bool, error)
+	AddDelivery(string, models.Delivery) error
+	GetDeliveries(string) ([]models.Delivery, error)
 }
 
 // Реализация MemoryStorage
 type MemoryStorage struct {
 	expressions     map[string]*models.Expression
 	tasks           map[string]*models.Task
-	pendingTasks    []string
+	queue           *taskQueue // Задачи, готовые к выполнению
 	processingTasks map[string]struct{}
+	dependents      map[string][]string // Задачи, ожидающие результата данной
+	exprTasks       map[string][]string // Задачи каждого выражения
+	stopped         map[string]error    // Отменённые, просроченные и упавшие выражения: их задачи больше не принимаются
+	functions       map[string]*models.Function
+	batches         map[string]*models.Batch
+	deliveries      map[string][]models.Delivery // Попытки доставки результата по callback_url
+	done            map[string]chan struct{}     // Закрываются, когда выражение больше не изменится
+	deadlines       map[string]*time.Timer       // Завершают выражения, не вычисленные к сроку
+	changeListeners []func(models.Expression)
+	finishListeners []func(models.Expression)
+	taskListeners   []func(models.Task)
+	idempotency     map[idempotencyScope]*models.IdempotencyKey
+	idempotencyTTL  time.Duration
+	nextSweep       time.Time // Когда удалить ключи идемпотентности с истёкшим сроком
+	taskTimeout     time.Duration
 	mu              sync.RWMutex
 }
 
@@ -31,8 +74,108 @@ func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
 		expressions:     make(map[string]*models.Expression),
 		tasks:           make(map[string]*models.Task),
-		pendingTasks:    make([]string, 0),
+		queue:           newTaskQueue(),
 		processingTasks: make(map[string]struct{}),
+		dependents:      make(map[string][]string),
+		exprTasks:       make(map[string][]string),
+		stopped:         make(map[string]error),
+		done:            make(map[string]chan struct{}),
+		deadlines:       make(map[string]*time.Timer),
+		functions:       make(map[string]*models.Function),
+		batches:         make(map[string]*models.Batch),
+		deliveries:      make(map[string][]models.Delivery),
+		idempotency:     make(map[idempotencyScope]*models.IdempotencyKey),
+		idempotencyTTL:  DefaultIdempotencyWindow,
+		taskTimeout:     DefaultTaskTimeout,
+	}
+}
+
+// SetTaskTimeout задаёт, сколько сверх времени операции ждать результата от агента.
+// Задача, по которой результат не пришёл, снова попадает в очередь
+func (s *MemoryStorage) SetTaskTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taskTimeout = timeout
+}
+
+// OnExpressionFinished подписывает fn на завершение выражений со статусом done или error.
+// fn получает копию выражения и вызывается под блокировкой хранилища, поэтому не должна
+// обращаться к нему и надолго задерживаться
+func (s *MemoryStorage) OnExpressionFinished(fn func(models.Expression)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishListeners = append(s.finishListeners, fn)
+}
+
+// OnExpressionChanged подписывает fn на добавление выражений и смену их статуса.
+// Ограничения те же, что у OnExpressionFinished
+func (s *MemoryStorage) OnExpressionChanged(fn func(models.Expression)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changeListeners = append(s.changeListeners, fn)
+}
+
+// OnTaskFinished подписывает fn на получение результата или ошибки задачи.
+// Ограничения те же, что у OnExpressionFinished
+func (s *MemoryStorage) OnTaskFinished(fn func(models.Task)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taskListeners = append(s.taskListeners, fn)
+}
+
+func (s *MemoryStorage) changed(expr *models.Expression) {
+	for _, fn := range s.changeListeners {
+		fn(*expr.Clone())
+	}
+	if expr.Status == "done" || expr.Status == "error" {
+		for _, fn := range s.finishListeners {
+			fn(*expr.Clone())
+		}
+	}
+	if finished(expr) {
+		s.wake(expr.ID)
+		s.stopDeadline(expr.ID)
+	}
+}
+
+// ExpressionDone возвращает канал, который закрывается, когда выражение завершено,
+// отменено или удалено. Для уже завершённого выражения канал закрыт сразу
+func (s *MemoryStorage) ExpressionDone(id string) (<-chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expr, exists := s.expressions[id]
+	if !exists {
+		return nil, errors.ErrExpressionNotFound
+	}
+	if finished(expr) {
+		closed := make(chan struct{})
+		close(closed)
+		return closed, nil
+	}
+	ch, ok := s.done[id]
+	if !ok {
+		ch = make(chan struct{})
+		s.done[id] = ch
+	}
+	return ch, nil
+}
+
+// wake будит всех, кто ждёт выражение
+func (s *MemoryStorage) wake(id string) {
+	if ch, ok := s.done[id]; ok {
+		close(ch)
+		delete(s.done, id)
+	}
+}
+
+func finished(expr *models.Expression) bool {
+	return expr.Status == "done" || expr.Status == "error" || expr.Status == "cancelled"
+}
+
+func (s *MemoryStorage) taskFinished(task *models.Task) {
+	for _, fn := range s.taskListeners {
+		fn(*task.Clone())
 	}
 }
 
@@ -41,20 +184,50 @@ func (s *MemoryStorage) AddExpression(expr *models.Expression) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.addExpression(expr)
+}
+
+func (s *MemoryStorage) addExpression(expr *models.Expression) error {
 	if _, exists := s.expressions[expr.ID]; exists {
 		return errors.ErrExpressionExists
 	}
 
-	s.expressions[expr.ID] = expr
+	s.expressions[expr.ID] = expr.Clone()
+	s.changed(expr)
+	if expr.Deadline != nil && !finished(expr) {
+		id := expr.ID
+		s.deadlines[id] = time.AfterFunc(time.Until(*expr.Deadline), func() { s.expire(id) })
+	}
 	return nil
 }
 
+// expire завершает выражение с ошибкой ErrTimeout, если к сроку оно не вычислено
+func (s *MemoryStorage) expire(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expr, exists := s.expressions[id]; exists && !finished(expr) {
+		s.stop(expr, errors.ErrTimeout)
+	}
+}
+
+// stopDeadline останавливает таймер срока выражения, которое больше не изменится
+func (s *MemoryStorage) stopDeadline(id string) {
+	if timer, ok := s.deadlines[id]; ok {
+		timer.Stop()
+		delete(s.deadlines, id)
+	}
+}
+
 func (s *MemoryStorage) GetExpression(id string) (*models.Expression, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	expr, exists := s.expressions[id]
-	return expr, exists
+	if !exists {
+		return nil, false
+	}
+	return expr.Clone(), true
 }
 
 func (s *MemoryStorage) GetAllExpressions() ([]*models.Expression, error) {
@@ -63,7 +236,7 @@ func (s *MemoryStorage) GetAllExpressions() ([]*models.Expression, error) {
 
 	result := make([]*models.Expression, 0, len(s.expressions))
 	for _, expr := range s.expressions {
-		result = append(result, expr)
+		result = append(result, expr.Clone())
 	}
 	return result, nil
 }
@@ -75,42 +248,234 @@ func (s *MemoryStorage) UpdateExpression(expr *models.Expression) error {
 	if _, exists := s.expressions[expr.ID]; !exists {
 		return errors.ErrExpressionNotFound
 	}
+	// Обработка, которая не успела узнать об отмене или истечении срока,
+	// не возвращает выражение к вычислению
+	if reason, ok := s.stopped[expr.ID]; ok {
+		stoppedStatus(expr, reason)
+		return reason
+	}
+
+	s.expressions[expr.ID] = expr.Clone()
+	s.changed(expr)
+	return nil
+}
+
+// CancelExpression останавливает вычисление выражения: невыполненные задачи убираются
+// из очереди и отмечаются отменёнными, а их результаты больше не принимаются
+func (s *MemoryStorage) CancelExpression(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expr, exists := s.expressions[id]
+	if !exists {
+		return errors.ErrExpressionNotFound
+	}
+	switch expr.Status {
+	case "cancelled":
+		return nil
+	case "done", "error":
+		return errors.ErrExpressionFinished
+	}
 
-	s.expressions[expr.ID] = expr
+	s.stop(expr, errors.ErrExpressionCancelled)
+	return nil
+}
+
+// DeleteExpression удаляет выражение и его задачи; вычисляющееся выражение сначала отменяется.
+// Задачи убираются из очереди и из выданных агентам при любом статусе выражения
+func (s *MemoryStorage) DeleteExpression(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expr, exists := s.expressions[id]
+	if !exists {
+		return errors.ErrExpressionNotFound
+	}
+	if expr.Status == "pending" || expr.Status == "processing" {
+		s.stop(expr, errors.ErrExpressionCancelled)
+	}
+
+	for _, taskID := range s.exprTasks[id] {
+		delete(s.tasks, taskID)
+		delete(s.dependents, taskID)
+		delete(s.processingTasks, taskID)
+		s.dequeue(taskID)
+	}
+	delete(s.exprTasks, id)
+	delete(s.deliveries, id)
+	delete(s.stopped, id)
+	delete(s.expressions, id)
+	s.wake(id)
+	s.stopDeadline(id)
 	return nil
 }
 
+// stop прекращает вычисление выражения: невыполненные задачи отмечаются отменёнными
+// и убираются из очереди. Причина ErrExpressionCancelled — отмена клиентом, выражение
+// получает статус cancelled; иначе выражение завершается с ошибкой reason
+func (s *MemoryStorage) stop(expr *models.Expression, reason error) {
+	now := time.Now()
+	s.stopped[expr.ID] = reason
+	s.cancelTasks(expr.ID, now)
+	stoppedStatus(expr, reason)
+	expr.UpdatedAt = now
+	s.changed(expr)
+}
+
+// cancelTasks отмечает невыполненные задачи выражения отменёнными и убирает их
+// из очереди и из выданных агентам
+func (s *MemoryStorage) cancelTasks(exprID string, now time.Time) {
+	for _, taskID := range s.exprTasks[exprID] {
+		task := s.tasks[taskID]
+		if task.Status == "done" || task.Status == "error" {
+			continue
+		}
+		task.Status = "cancelled"
+		task.FinishedAt = &now
+		delete(s.processingTasks, taskID)
+		s.dequeue(taskID)
+	}
+}
+
+func stoppedStatus(expr *models.Expression, reason error) {
+	if reason == errors.ErrExpressionCancelled {
+		expr.Status = "cancelled"
+		return
+	}
+	expr.Status = "error"
+	expr.Error = reason.Error()
+}
+
 // Методы для работы с задачами
 
 func (s *MemoryStorage) AddTask(task *models.Task) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.tasks[task.ID]; exists {
-		return errors.ErrTaskExists
+	return s.addTasks(task.ExpressionID, []*models.Task{task})
+}
+
+// AddTasks добавляет все задачи выражения разом: агент не получит ни одну из них, пока
+// не добавлены остальные, поэтому выражение не будет сочтено вычисленным раньше времени
+func (s *MemoryStorage) AddTasks(exprID string, tasks []*models.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addTasks(exprID, tasks)
+}
+
+func (s *MemoryStorage) addTasks(exprID string, tasks []*models.Task) error {
+	for _, task := range tasks {
+		if _, exists := s.tasks[task.ID]; exists {
+			return errors.ErrTaskExists
+		}
+	}
+	if reason, ok := s.stopped[exprID]; ok {
+		return reason
+	}
+	// Выражение могли удалить, пока оно разбиралось
+	if _, exists := s.expressions[exprID]; !exists {
+		return errors.ErrExpressionNotFound
+	}
+
+	stored := make([]*models.Task, len(tasks))
+	for i, task := range tasks {
+		task.ExpressionID = exprID
+		stored[i] = task.Clone()
+		s.tasks[task.ID] = stored[i]
+		s.exprTasks[exprID] = append(s.exprTasks[exprID], task.ID)
 	}
 
-	s.tasks[task.ID] = task
-	s.pendingTasks = append(s.pendingTasks, task.ID)
+	// Задача попадает в очередь, только когда известны все её операнды
+	now := time.Now()
+	for _, task := range stored {
+		ready := true
+		for _, dep := range task.UniqueDependencies() {
+			if depTask, ok := s.tasks[dep]; ok && depTask.Status == "done" {
+				task.FillOperand(depTask)
+				continue
+			}
+			s.dependents[dep] = append(s.dependents[dep], task.ID)
+			ready = false
+		}
+		if ready {
+			s.enqueue(task, now)
+		}
+	}
 	return nil
 }
 
+// enqueue ставит задачу в очередь с приоритетом и клиентом её выражения
+func (s *MemoryStorage) enqueue(task *models.Task, now time.Time) {
+	priority, tenant := 0, ""
+	if expr, ok := s.expressions[task.ExpressionID]; ok {
+		priority, tenant = expr.Priority, expr.Tenant
+	}
+	s.queue.push(task.ID, tenant, priority, now)
+}
+
+// SetAgingInterval задаёт, сколько ожидания в очереди стоит одна ступень приоритета
+func (s *MemoryStorage) SetAgingInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue.agingInterval = interval
+}
+
+// SetTenantWeight задаёт вес клиента: при конкуренции за агентов клиенты получают
+// задачи пропорционально весам. По умолчанию вес равен 1
+func (s *MemoryStorage) SetTenantWeight(tenant string, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue.weights[tenant] = weight
+}
+
 func (s *MemoryStorage) GetNextTask() (*models.Task, error) {
+	return s.GetNextTaskForAgent("")
+}
+
+// GetNextTaskForAgent выдаёт следующую задачу из очереди и запоминает агента, который её выполняет
+func (s *MemoryStorage) GetNextTaskForAgent(agentID string) (*models.Task, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(s.pendingTasks) == 0 {
+	now := time.Now()
+	s.requeueExpired(now)
+	taskID, ok := s.queue.pop()
+	if !ok {
 		return nil, errors.ErrTaskNotFound
 	}
 
-	taskID := s.pendingTasks[0]
-	s.pendingTasks = s.pendingTasks[1:]
-
 	task := s.tasks[taskID]
 	task.Status = "processing"
+	task.AgentID = agentID
+	task.StartedAt = &now
 	s.processingTasks[taskID] = struct{}{}
 
-	return task, nil
+	return task.Clone(), nil
+}
+
+// requeueExpired возвращает в очередь задачи, результат которых агент не прислал вовремя
+func (s *MemoryStorage) requeueExpired(now time.Time) {
+	for id := range s.processingTasks {
+		task, exists := s.tasks[id]
+		if !exists {
+			delete(s.processingTasks, id)
+			continue
+		}
+		deadline := task.StartedAt.Add(time.Duration(task.OperationTime)*time.Millisecond + s.taskTimeout)
+		if now.Before(deadline) {
+			continue
+		}
+		delete(s.processingTasks, id)
+		task.Status = "pending"
+		task.Retries++
+		s.enqueue(task, now)
+	}
+}
+
+// dequeue убирает задачу из очереди, если она там есть
+func (s *MemoryStorage) dequeue(taskID string) {
+	s.queue.remove(taskID)
 }
 
 func (s *MemoryStorage) UpdateTask(task *models.Task) error {
@@ -121,11 +486,20 @@ func (s *MemoryStorage) UpdateTask(task *models.Task) error {
 		return errors.ErrTaskNotFound
 	}
 
-	s.tasks[task.ID] = task
+	s.tasks[task.ID] = task.Clone()
 	return nil
 }
 
 func (s *MemoryStorage) CompleteTask(taskID string, result float64) error {
+	return s.completeTask(taskID, result, nil)
+}
+
+// CompleteArrayTask завершает задачу, результат которой — массив (matmul, inv)
+func (s *MemoryStorage) CompleteArrayTask(taskID string, results []float64) error {
+	return s.completeTask(taskID, 0, results)
+}
+
+func (s *MemoryStorage) completeTask(taskID string, result float64, results []float64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -133,30 +507,179 @@ func (s *MemoryStorage) CompleteTask(taskID string, result float64) error {
 	if !exists {
 		return errors.ErrTaskNotFound
 	}
+	// Повторно выданную задачу могут выполнить двое агентов: засчитывается первый результат
+	if task.Status == "done" {
+		return nil
+	}
+	// Результат задачи отменённого или просроченного выражения не учитывается
+	if task.Status == "cancelled" {
+		return s.stopped[task.ExpressionID]
+	}
 
+	now := time.Now()
 	task.Status = "done"
 	task.Result = result
+	task.Results = results
+	task.FinishedAt = &now
 	delete(s.processingTasks, taskID)
+	s.dequeue(taskID)
+	s.taskFinished(task)
 
 	// Обновляем статус выражения
 	expr, exists := s.expressions[task.ExpressionID]
 	if !exists {
 		return errors.ErrExpressionNotFound
 	}
+	if expr.Status == "error" {
+		return nil
+	}
 
-	// Логика обновления статуса выражения
-	// (должна проверять завершение всех задач выражения)
-	expr.Status = "processing"
+	// Передаём результат зависимым задачам и ставим готовые в очередь
+	for _, depID := range s.dependents[taskID] {
+		dep := s.tasks[depID]
+		dep.FillOperand(task)
+		if s.operandsReady(dep) {
+			s.enqueue(dep, now)
+		}
+	}
+	delete(s.dependents, taskID)
+
+	// Выражение готово, когда выполнены все его задачи; последней завершается корневая
+	for _, id := range s.exprTasks[task.ExpressionID] {
+		if s.tasks[id].Status != "done" {
+			expr.UpdatedAt = time.Now()
+			if expr.Status != "processing" {
+				expr.Status = "processing"
+				s.changed(expr)
+			}
+			return nil
+		}
+	}
+	expr.Status = "done"
+	if expr.Results == nil {
+		expr.Result = result
+	}
+	for i, id := range expr.ResultTasks {
+		if id != "" {
+			expr.Results[i] = s.tasks[id].Value(expr.ResultIndexAt(i))
+		}
+	}
+	expr.FormatResult()
+	expr.UpdatedAt = time.Now()
+	s.changed(expr)
 
 	return nil
 }
 
+// FailTask отмечает задачу, которую агент не смог выполнить; выражение завершается
+// с ошибкой, а его невыполненные задачи отменяются, и их результаты больше не принимаются
+func (s *MemoryStorage) FailTask(taskID string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, exists := s.tasks[taskID]
+	if !exists {
+		return errors.ErrTaskNotFound
+	}
+	if task.Status == "done" {
+		return nil
+	}
+	// Результат задачи отменённого или просроченного выражения не учитывается
+	if task.Status == "cancelled" {
+		return s.stopped[task.ExpressionID]
+	}
+
+	now := time.Now()
+	task.Status = "error"
+	task.Error = message
+	task.FinishedAt = &now
+	delete(s.processingTasks, taskID)
+	s.dequeue(taskID)
+	s.taskFinished(task)
+
+	expr, exists := s.expressions[task.ExpressionID]
+	if !exists {
+		return errors.ErrExpressionNotFound
+	}
+	s.stopped[expr.ID] = errors.ErrExpressionFailed
+	s.cancelTasks(expr.ID, now)
+	if expr.Status != "error" {
+		expr.Status = "error"
+		expr.Error = message
+		expr.UpdatedAt = now
+		s.changed(expr)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) operandsReady(task *models.Task) bool {
+	for _, dep := range task.Dependencies() {
+		if s.tasks[dep].Status != "done" {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *MemoryStorage) GetTask(taskID string) (*models.Task, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	task, exists := s.tasks[taskID]
-	return task, exists
+	if !exists {
+		return nil, false
+	}
+	return task.Clone(), true
+}
+
+// GetExpressionTasks возвращает задачи выражения в порядке их создания
+func (s *MemoryStorage) GetExpressionTasks(exprID string) ([]*models.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.expressions[exprID]; !exists {
+		return nil, errors.ErrExpressionNotFound
+	}
+
+	result := make([]*models.Task, 0, len(s.exprTasks[exprID]))
+	for _, id := range s.exprTasks[exprID] {
+		result = append(result, s.tasks[id].Clone())
+	}
+	return result, nil
+}
+
+// Методы для работы с пользовательскими функциями
+
+func (s *MemoryStorage) AddFunction(fn *models.Function) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.functions[fn.Name]; exists {
+		return errors.ErrFunctionExists
+	}
+
+	s.functions[fn.Name] = fn
+	return nil
+}
+
+func (s *MemoryStorage) GetFunction(name string) (*models.Function, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fn, exists := s.functions[name]
+	return fn, exists
+}
+
+func (s *MemoryStorage) GetAllFunctions() ([]*models.Function, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*models.Function, 0, len(s.functions))
+	for _, fn := range s.functions {
+		result = append(result, fn)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
 }
 
 // Вспомогательные методы
@@ -164,7 +687,7 @@ func (s *MemoryStorage) GetTask(taskID string) (*models.Task, bool) {
 func (s *MemoryStorage) GetPendingTasksCount() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return len(s.pendingTasks)
+	return s.queue.len()
 }
 
 func (s *MemoryStorage) GetProcessingTasksCount() int {
@@ -172,3 +695,44 @@ func (s *MemoryStorage) GetProcessingTasksCount() int {
 	defer s.mu.RUnlock()
 	return len(s.processingTasks)
 }
+
+func (s *MemoryStorage) AddBatch(batch *models.Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.batches[batch.ID]; exists {
+		return errors.ErrBatchExists
+	}
+	s.batches[batch.ID] = batch
+	return nil
+}
+
+func (s *MemoryStorage) GetBatch(id string) (*models.Batch, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	batch, exists := s.batches[id]
+	return batch, exists
+}
+
+// AddDelivery записывает попытку доставки результата выражения по callback_url
+func (s *MemoryStorage) AddDelivery(exprID string, delivery models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.expressions[exprID]; !exists {
+		return errors.ErrExpressionNotFound
+	}
+	s.deliveries[exprID] = append(s.deliveries[exprID], delivery)
+	return nil
+}
+
+func (s *MemoryStorage) GetDeliveries(exprID string) ([]models.Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, exists := s.expressions[exprID]; !exists {
+		return nil, errors.ErrExpressionNotFound
+	}
+	return append([]models.Delivery{}, s.deliveries[exprID]...), nil
+}
@@ -1,6 +1,7 @@
 package storage_test
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -76,3 +77,572 @@ func TestMemoryStorage(t *testing.T) {
 		assert.ErrorIs(t, err, errors.ErrTaskNotFound)
 	})
 }
+
+func TestTaskDependencies(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+
+	// (2+3)*4: умножение ждёт результата сложения
+	sum := &models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"}
+	mul := &models.Task{ID: "mul", ExpressionID: "expr", Operation: "*", Arg1Task: "sum", Arg2: 4, Status: "pending"}
+	assert.NoError(t, store.AddTask(sum))
+	assert.NoError(t, store.AddTask(mul))
+	assert.Equal(t, 1, store.GetPendingTasksCount())
+
+	next, err := store.GetNextTask()
+	assert.NoError(t, err)
+	assert.Equal(t, "sum", next.ID)
+
+	_, err = store.GetNextTask()
+	assert.ErrorIs(t, err, errors.ErrTaskNotFound)
+
+	assert.NoError(t, store.CompleteTask("sum", 5))
+	next, err = store.GetNextTask()
+	assert.NoError(t, err)
+	assert.Equal(t, "mul", next.ID)
+	assert.Equal(t, 5.0, next.Arg1)
+
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "processing", expr.Status)
+
+	assert.NoError(t, store.CompleteTask("mul", 20))
+	expr, _ = store.GetExpression("expr")
+	assert.Equal(t, "done", expr.Status)
+	assert.Equal(t, 20.0, expr.Result)
+}
+
+func TestAddTasks(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+
+	// 2+3*4: задачи плана добавляются разом, и результат умножения не завершает выражение
+	mul := &models.Task{ID: "mul", Operation: "*", Arg1: 3, Arg2: 4, Status: "pending"}
+	sum := &models.Task{ID: "sum", Operation: "+", Arg1: 2, Arg2Task: "mul", Status: "pending"}
+	assert.NoError(t, store.AddTasks("expr", []*models.Task{mul, sum}))
+	assert.Equal(t, "expr", sum.ExpressionID)
+	assert.Equal(t, 1, store.GetPendingTasksCount())
+
+	next, _ := store.GetNextTask()
+	assert.Equal(t, "mul", next.ID)
+	assert.NoError(t, store.CompleteTask("mul", 12))
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "processing", expr.Status)
+
+	next, _ = store.GetNextTask()
+	assert.Equal(t, "sum", next.ID)
+	assert.NoError(t, store.CompleteTask("sum", 14))
+	expr, _ = store.GetExpression("expr")
+	assert.Equal(t, "done", expr.Status)
+	assert.Equal(t, 14.0, expr.Result)
+
+	// Ни одна задача не добавляется, если хотя бы одна уже есть
+	other := &models.Task{ID: "other", Operation: "+", Status: "pending"}
+	assert.ErrorIs(t, store.AddTasks("expr", []*models.Task{other, sum}), errors.ErrTaskExists)
+	_, exists := store.GetTask("other")
+	assert.False(t, exists)
+}
+
+func TestStorageCopies(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	expr := &models.Expression{ID: "expr", Status: "processing", Results: []float64{0, 0}}
+	store.AddExpression(expr)
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+
+	// Изменения полученных и переданных значений не попадают в хранилище
+	expr.Status = "done"
+	got, _ := store.GetExpression("expr")
+	assert.Equal(t, "processing", got.Status)
+	got.Results[0] = 7
+	got, _ = store.GetExpression("expr")
+	assert.Equal(t, []float64{0, 0}, got.Results)
+
+	task, err := store.GetNextTaskForAgent("agent-1")
+	assert.NoError(t, err)
+	task.Status = "done"
+	stored, _ := store.GetTask("sum")
+	assert.Equal(t, "processing", stored.Status)
+	assert.Equal(t, "agent-1", stored.AgentID)
+}
+
+func TestFunctionStorage(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	fn := &models.Function{Name: "hyp", Params: []string{"a", "b"}, Body: "sqrt(a*a+b*b)"}
+
+	assert.NoError(t, store.AddFunction(fn))
+	assert.ErrorIs(t, store.AddFunction(fn), errors.ErrFunctionExists)
+
+	retrieved, exists := store.GetFunction("hyp")
+	assert.True(t, exists)
+	assert.Equal(t, fn.Body, retrieved.Body)
+
+	all, err := store.GetAllFunctions()
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestListResult(t *testing.T) {
+	store := storage.NewMemoryStorage()
+
+	// [2+3, 4, median(2+3, 1, 0)]: результат — список, медиана ждёт сложения
+	store.AddExpression(&models.Expression{
+		ID:          "expr",
+		Status:      "processing",
+		Results:     []float64{0, 4, 0},
+		ResultTasks: []string{"sum", "", "median"},
+	})
+	sum := &models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"}
+	med := &models.Task{
+		ID:           "median",
+		ExpressionID: "expr",
+		Operation:    "median",
+		Args:         []float64{0, 1, 0},
+		ArgTasks:     []string{"sum", "", ""},
+		Status:       "pending",
+	}
+	assert.NoError(t, store.AddTask(sum))
+	assert.NoError(t, store.AddTask(med))
+
+	store.GetNextTask()
+	assert.NoError(t, store.CompleteTask("sum", 5))
+
+	next, err := store.GetNextTask()
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{5, 1, 0}, next.Args)
+	assert.NoError(t, store.CompleteTask("median", 1))
+
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "done", expr.Status)
+	assert.Equal(t, []float64{5, 4, 1}, expr.Results)
+}
+
+func TestArrayTask(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+
+	// Элемент [1][0] результата matmul, умноженный на 2
+	mm := &models.Task{ID: "mm", ExpressionID: "expr", Operation: "matmul", Args: []float64{1, 2, 3, 4, 1, 0, 0, 1}, Shape: []int{2, 2, 2}, Status: "pending"}
+	mul := &models.Task{ID: "mul", ExpressionID: "expr", Operation: "*", Arg1Task: "mm", Arg1Index: 2, Arg2: 2, Status: "pending"}
+	assert.NoError(t, store.AddTask(mm))
+	assert.NoError(t, store.AddTask(mul))
+
+	store.GetNextTask()
+	assert.NoError(t, store.CompleteArrayTask("mm", []float64{1, 2, 3, 4}))
+
+	next, err := store.GetNextTask()
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, next.Arg1)
+}
+
+func TestTaskRetry(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.SetTaskTimeout(0)
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+
+	first, err := store.GetNextTask()
+	assert.NoError(t, err)
+	assert.NotNil(t, first.StartedAt)
+	assert.Equal(t, 0, first.Retries)
+
+	// Агент не прислал результат вовремя: задача выдаётся повторно
+	retried, err := store.GetNextTask()
+	assert.NoError(t, err)
+	assert.Equal(t, "sum", retried.ID)
+	assert.Equal(t, 1, retried.Retries)
+
+	assert.NoError(t, store.CompleteTask("sum", 5))
+	assert.NoError(t, store.CompleteTask("sum", 5), "запоздавший результат игнорируется")
+
+	task, _ := store.GetTask("sum")
+	assert.Equal(t, "done", task.Status)
+	assert.NotNil(t, task.FinishedAt)
+	assert.Equal(t, 0, store.GetPendingTasksCount())
+}
+
+func TestFailTask(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+
+	// 1/0 + 2*3: деление падает, умножение убирается из очереди
+	store.AddTask(&models.Task{ID: "div", ExpressionID: "expr", Operation: "/", Arg1: 1, Arg2: 0, Status: "pending"})
+	store.AddTask(&models.Task{ID: "mul", ExpressionID: "expr", Operation: "*", Arg1: 2, Arg2: 3, Status: "pending"})
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1Task: "div", Arg2Task: "mul", Status: "pending"})
+
+	next, _ := store.GetNextTask()
+	assert.Equal(t, "div", next.ID)
+	assert.NoError(t, store.FailTask("div", errors.ErrDivisionByZero.Error()))
+
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, errors.ErrDivisionByZero.Error(), expr.Error)
+	assert.Equal(t, 0, store.GetPendingTasksCount())
+
+	task, _ := store.GetTask("div")
+	assert.Equal(t, "error", task.Status)
+	assert.ErrorIs(t, store.FailTask("invalid", ""), errors.ErrTaskNotFound)
+}
+
+func TestFailTaskCancelsSiblings(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.SetTaskTimeout(0)
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+	store.AddTask(&models.Task{ID: "div", ExpressionID: "expr", Operation: "/", Arg1: 1, Arg2: 0, Status: "pending"})
+	store.AddTask(&models.Task{ID: "mul", ExpressionID: "expr", Operation: "*", Arg1: 2, Arg2: 3, Status: "pending"})
+
+	// Обе задачи выданы агентам, и деление падает
+	store.GetNextTask()
+	store.GetNextTask()
+	assert.NoError(t, store.FailTask("div", errors.ErrDivisionByZero.Error()))
+
+	// Умножение отменено и не выдаётся повторно по таймауту
+	task, _ := store.GetTask("mul")
+	assert.Equal(t, "cancelled", task.Status)
+	assert.Equal(t, 0, store.GetProcessingTasksCount())
+	_, err := store.GetNextTask()
+	assert.ErrorIs(t, err, errors.ErrTaskNotFound)
+
+	// Запоздавший результат не принимается, выражение остаётся с ошибкой деления
+	assert.ErrorIs(t, store.CompleteTask("mul", 6), errors.ErrExpressionFailed)
+	assert.ErrorIs(t, store.FailTask("mul", "сбой"), errors.ErrExpressionFailed)
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, errors.ErrDivisionByZero.Error(), expr.Error)
+}
+
+func TestCancelExpression(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+	store.AddTask(&models.Task{ID: "mul", ExpressionID: "expr", Operation: "*", Arg1: 2, Arg2: 3, Status: "pending"})
+	store.AddTask(&models.Task{ID: "div", ExpressionID: "expr", Operation: "/", Arg1: 1, Arg2: 4, Status: "pending"})
+
+	// Одна задача уже у агента, другая ждёт в очереди
+	next, _ := store.GetNextTask()
+	assert.Equal(t, "mul", next.ID)
+
+	assert.NoError(t, store.CancelExpression("expr"))
+	assert.NoError(t, store.CancelExpression("expr"), "повторная отмена ничего не меняет")
+
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "cancelled", expr.Status)
+	assert.Equal(t, 0, store.GetPendingTasksCount())
+	_, err := store.GetNextTask()
+	assert.ErrorIs(t, err, errors.ErrTaskNotFound)
+
+	// Запоздавший результат отклоняется, новые задачи не принимаются
+	assert.ErrorIs(t, store.CompleteTask("mul", 6), errors.ErrExpressionCancelled)
+	assert.ErrorIs(t, store.FailTask("mul", ""), errors.ErrExpressionCancelled)
+	assert.ErrorIs(t, store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Status: "pending"}), errors.ErrExpressionCancelled)
+
+	// Обработка, не знающая об отмене, не возвращает выражение к вычислению
+	expr.Status = "processing"
+	assert.ErrorIs(t, store.UpdateExpression(expr), errors.ErrExpressionCancelled)
+	assert.Equal(t, "cancelled", expr.Status)
+
+	task, _ := store.GetTask("div")
+	assert.Equal(t, "cancelled", task.Status)
+
+	store.AddExpression(&models.Expression{ID: "done", Status: "done"})
+	assert.ErrorIs(t, store.CancelExpression("done"), errors.ErrExpressionFinished)
+	assert.ErrorIs(t, store.CancelExpression("invalid"), errors.ErrExpressionNotFound)
+}
+
+func TestDeleteExpression(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+
+	assert.NoError(t, store.DeleteExpression("expr"))
+
+	_, exists := store.GetExpression("expr")
+	assert.False(t, exists)
+	_, exists = store.GetTask("sum")
+	assert.False(t, exists)
+	assert.Equal(t, 0, store.GetPendingTasksCount())
+	assert.ErrorIs(t, store.CompleteTask("sum", 5), errors.ErrTaskNotFound)
+	assert.ErrorIs(t, store.DeleteExpression("expr"), errors.ErrExpressionNotFound)
+
+	// Задачи удалённого выражения, которое ещё разбиралось, не добавляются
+	late := &models.Task{ID: "late", Operation: "+", Status: "pending"}
+	assert.ErrorIs(t, store.AddTasks("expr", []*models.Task{late}), errors.ErrExpressionNotFound)
+	assert.Equal(t, 0, store.GetPendingTasksCount())
+}
+
+func TestDeleteFailedExpression(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.SetTaskTimeout(0)
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+	store.AddTask(&models.Task{ID: "div", ExpressionID: "expr", Operation: "/", Arg1: 1, Arg2: 0, Status: "pending"})
+	store.AddTask(&models.Task{ID: "mul", ExpressionID: "expr", Operation: "*", Arg1: 2, Arg2: 3, Status: "pending"})
+
+	// Обе задачи выданы агентам, деление падает, и выражение с ошибкой удаляется
+	store.GetNextTask()
+	store.GetNextTask()
+	assert.NoError(t, store.FailTask("div", errors.ErrDivisionByZero.Error()))
+	assert.NoError(t, store.DeleteExpression("expr"))
+
+	// Выданное умножение не возвращается в очередь по таймауту
+	_, err := store.GetNextTaskForAgent("agent-1")
+	assert.ErrorIs(t, err, errors.ErrTaskNotFound)
+	assert.Equal(t, 0, store.GetProcessingTasksCount())
+	assert.Equal(t, 0, store.GetPendingTasksCount())
+}
+
+func TestQueryExpressions(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Пять выражений: у b и c одинаковое время создания, порядок между ними задаёт ID
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		created := base.Add(time.Duration(i) * time.Minute)
+		if id == "c" {
+			created = base.Add(time.Minute)
+		}
+		status := "done"
+		if id == "d" {
+			status = "error"
+		}
+		store.AddExpression(&models.Expression{ID: id, Status: status, CreatedAt: created})
+	}
+
+	ids := func(page *storage.ExpressionPage) []string {
+		var result []string
+		for _, expr := range page.Expressions {
+			result = append(result, expr.ID)
+		}
+		return result
+	}
+
+	t.Run("Постраничная выдача", func(t *testing.T) {
+		page, err := store.QueryExpressions(storage.ExpressionQuery{Limit: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, ids(page))
+		assert.Equal(t, 5, page.Total)
+
+		// Выражение, добавленное между запросами, не сдвигает следующую страницу
+		store.AddExpression(&models.Expression{ID: "0", Status: "done", CreatedAt: base.Add(-time.Minute)})
+		defer store.DeleteExpression("0")
+
+		page, err = store.QueryExpressions(storage.ExpressionQuery{Limit: 2, Cursor: page.NextCursor})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"c", "d"}, ids(page))
+
+		page, err = store.QueryExpressions(storage.ExpressionQuery{Limit: 2, Cursor: page.NextCursor})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"e"}, ids(page))
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("Фильтры и обратный порядок", func(t *testing.T) {
+		page, err := store.QueryExpressions(storage.ExpressionQuery{
+			Status:       "done",
+			CreatedAfter: base,
+			Descending:   true,
+			Limit:        2,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"e", "c"}, ids(page))
+		assert.Equal(t, 3, page.Total)
+
+		page, err = store.QueryExpressions(storage.ExpressionQuery{Status: "done", CreatedAfter: base, Descending: true, Cursor: page.NextCursor})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b"}, ids(page))
+	})
+
+	t.Run("Некорректный курсор", func(t *testing.T) {
+		_, err := store.QueryExpressions(storage.ExpressionQuery{Cursor: "???"})
+		assert.ErrorIs(t, err, errors.ErrInvalidCursor)
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.SetIdempotencyWindow(50 * time.Millisecond)
+
+	add := func(id, tenant string) (*models.IdempotencyKey, bool, error) {
+		key := &models.IdempotencyKey{Key: "k", Tenant: tenant, ExpressionID: id, CreatedAt: time.Now()}
+		return store.AddExpressionWithKey(&models.Expression{ID: id, Status: "pending"}, key)
+	}
+
+	claimed, created, err := add("expr1", "")
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "expr1", claimed.ExpressionID)
+	_, exists := store.GetExpression("expr1")
+	assert.True(t, exists)
+
+	// Повтор в пределах окна получает запись первого запроса, выражение не сохраняется
+	claimed, created, err = add("expr2", "")
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "expr1", claimed.ExpressionID)
+	_, exists = store.GetExpression("expr2")
+	assert.False(t, exists)
+
+	// После окна ключ можно использовать снова
+	time.Sleep(60 * time.Millisecond)
+	claimed, created, _ = add("expr3", "")
+	assert.True(t, created)
+	assert.Equal(t, "expr3", claimed.ExpressionID)
+
+	// Ключи разных клиентов не пересекаются
+	claimed, created, _ = add("expr4", "web")
+	assert.True(t, created)
+	assert.Equal(t, "expr4", claimed.ExpressionID)
+
+	// Ключ не занимается, если выражение не сохранено
+	_, _, err = store.AddExpressionWithKey(&models.Expression{ID: "expr1"}, &models.IdempotencyKey{Key: "other", ExpressionID: "expr1", CreatedAt: time.Now()})
+	assert.ErrorIs(t, err, errors.ErrExpressionExists)
+	claimed, created, _ = store.AddExpressionWithKey(&models.Expression{ID: "expr5"}, &models.IdempotencyKey{Key: "other", ExpressionID: "expr5", CreatedAt: time.Now()})
+	assert.True(t, created)
+	assert.Equal(t, "expr5", claimed.ExpressionID)
+}
+
+func TestExpressionDone(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing"})
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+
+	done, err := store.ExpressionDone("expr")
+	assert.NoError(t, err)
+	select {
+	case <-done:
+		t.Fatal("Канал не должен закрываться до завершения выражения")
+	default:
+	}
+
+	store.CompleteTask("sum", 5)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Канал должен закрыться после завершения выражения")
+	}
+
+	// Для завершённого выражения канал закрыт сразу
+	done, _ = store.ExpressionDone("expr")
+	_, open := <-done
+	assert.False(t, open)
+
+	// Удаление будит ожидающих
+	store.AddExpression(&models.Expression{ID: "deleted", Status: "processing"})
+	done, _ = store.ExpressionDone("deleted")
+	store.DeleteExpression("deleted")
+	_, open = <-done
+	assert.False(t, open)
+
+	_, err = store.ExpressionDone("invalid")
+	assert.ErrorIs(t, err, errors.ErrExpressionNotFound)
+}
+
+func TestTaskPriority(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.AddExpression(&models.Expression{ID: "low", Status: "processing"})
+	store.AddExpression(&models.Expression{ID: "high", Status: "processing", Priority: 5})
+
+	store.AddTask(&models.Task{ID: "low1", ExpressionID: "low", Status: "pending"})
+	store.AddTask(&models.Task{ID: "low2", ExpressionID: "low", Status: "pending"})
+	store.AddTask(&models.Task{ID: "high1", ExpressionID: "high", Status: "pending"})
+
+	var order []string
+	for {
+		task, err := store.GetNextTask()
+		if err != nil {
+			break
+		}
+		order = append(order, task.ID)
+	}
+	assert.Equal(t, []string{"high1", "low1", "low2"}, order)
+}
+
+func TestTaskAging(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.SetAgingInterval(10 * time.Millisecond)
+	store.AddExpression(&models.Expression{ID: "low", Status: "processing"})
+	store.AddExpression(&models.Expression{ID: "high", Status: "processing", Priority: 2})
+
+	// Задача без приоритета ждёт дольше двух ступеней и обгоняет более новую приоритетную
+	store.AddTask(&models.Task{ID: "old", ExpressionID: "low", Status: "pending"})
+	time.Sleep(30 * time.Millisecond)
+	store.AddTask(&models.Task{ID: "new", ExpressionID: "high", Status: "pending"})
+
+	next, _ := store.GetNextTask()
+	assert.Equal(t, "old", next.ID)
+}
+
+func TestTenantFairShare(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	store.SetTenantWeight("web", 2)
+	store.AddExpression(&models.Expression{ID: "batch", Status: "processing", Tenant: "batch"})
+	store.AddExpression(&models.Expression{ID: "web", Status: "processing", Tenant: "web"})
+
+	// Пакетный клиент поставил много задач раньше, но интерактивный не ждёт их все
+	for i := 0; i < 10; i++ {
+		store.AddTask(&models.Task{ID: fmt.Sprint("batch", i), ExpressionID: "batch", Status: "pending"})
+	}
+	for i := 0; i < 4; i++ {
+		store.AddTask(&models.Task{ID: fmt.Sprint("web", i), ExpressionID: "web", Status: "pending"})
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		task, _ := store.GetNextTask()
+		counts[task.ExpressionID]++
+	}
+	// Вес 2 против 1: из шести задач четыре достаются web
+	assert.Equal(t, map[string]int{"web": 4, "batch": 2}, counts)
+
+	// Отменённые задачи уходят из очереди клиента
+	store.CancelExpression("web")
+	for i := 0; i < 8; i++ {
+		task, err := store.GetNextTask()
+		assert.NoError(t, err)
+		assert.Equal(t, "batch", task.ExpressionID)
+	}
+	_, err := store.GetNextTask()
+	assert.ErrorIs(t, err, errors.ErrTaskNotFound)
+}
+
+func TestExpressionTimeout(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	deadline := time.Now().Add(20 * time.Millisecond)
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing", Deadline: &deadline})
+	store.AddTask(&models.Task{ID: "mul", ExpressionID: "expr", Operation: "*", Arg1: 2, Arg2: 3, Status: "pending"})
+	store.AddTask(&models.Task{ID: "div", ExpressionID: "expr", Operation: "/", Arg1: 1, Arg2: 4, Status: "pending"})
+
+	next, _ := store.GetNextTask()
+	assert.Equal(t, "mul", next.ID)
+
+	done, _ := store.ExpressionDone("expr")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Выражение должно завершиться по истечении срока")
+	}
+
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "error", expr.Status)
+	assert.Equal(t, errors.ErrTimeout.Error(), expr.Error)
+	assert.Equal(t, 0, store.GetPendingTasksCount())
+
+	// Запоздавшие результаты не учитываются, новые задачи не принимаются
+	assert.ErrorIs(t, store.CompleteTask("mul", 6), errors.ErrTimeout)
+	assert.ErrorIs(t, store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Status: "pending"}), errors.ErrTimeout)
+	expr.Status = "processing"
+	assert.ErrorIs(t, store.UpdateExpression(expr), errors.ErrTimeout)
+	assert.Equal(t, "error", expr.Status)
+
+	task, _ := store.GetTask("div")
+	assert.Equal(t, "cancelled", task.Status)
+	assert.ErrorIs(t, store.CancelExpression("expr"), errors.ErrExpressionFinished)
+}
+
+func TestExpressionFinishedBeforeDeadline(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	deadline := time.Now().Add(20 * time.Millisecond)
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing", Deadline: &deadline})
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+	store.CompleteTask("sum", 5)
+
+	time.Sleep(40 * time.Millisecond)
+	expr, _ := store.GetExpression("expr")
+	assert.Equal(t, "done", expr.Status)
+	assert.Equal(t, 5.0, expr.Result)
+}
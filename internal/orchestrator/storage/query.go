@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"calc_service/pkg/errors"
+	"calc_service/pkg/models"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExpressionQuery — условия выборки выражений. Выражения упорядочены по времени создания,
+// при равном времени — по ID, поэтому курсор остаётся верным, пока добавляются новые выражения
+type ExpressionQuery struct {
+	Status        string    // Только выражения с этим статусом; пусто — любые
+	CreatedAfter  time.Time // Созданные строго позже; нулевое время — без ограничения
+	CreatedBefore time.Time // Созданные строго раньше
+	Descending    bool      // Сначала новые
+	Limit         int       // Размер страницы; 0 — все подходящие выражения
+	Cursor        string    // NextCursor предыдущей страницы
+}
+
+// ExpressionPage — страница выборки
+type ExpressionPage struct {
+	Expressions []*models.Expression
+	Total       int    // Сколько выражений подходит под условия на всех страницах
+	NextCursor  string // Курсор следующей страницы; пусто — страница последняя
+}
+
+func (s *MemoryStorage) QueryExpressions(q ExpressionQuery) (*ExpressionPage, error) {
+	var after *position
+	if q.Cursor != "" {
+		pos, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &pos
+	}
+
+	s.mu.RLock()
+	matched := make([]*models.Expression, 0, len(s.expressions))
+	for _, expr := range s.expressions {
+		if q.matches(expr) {
+			matched = append(matched, expr)
+		}
+	}
+	s.mu.RUnlock()
+
+	less := func(a, b position) bool {
+		if q.Descending {
+			return b.before(a)
+		}
+		return a.before(b)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return less(positionOf(matched[i]), positionOf(matched[j]))
+	})
+
+	page := &ExpressionPage{Total: len(matched)}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return less(*after, positionOf(matched[i]))
+		})
+	}
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		page.NextCursor = positionOf(matched[end-1]).encode()
+	}
+	// Сортировка читает только неизменяемые ID и CreatedAt; выдаются копии выражений
+	page.Expressions = make([]*models.Expression, 0, end-start)
+	s.mu.RLock()
+	for _, expr := range matched[start:end] {
+		page.Expressions = append(page.Expressions, expr.Clone())
+	}
+	s.mu.RUnlock()
+	return page, nil
+}
+
+func (q ExpressionQuery) matches(expr *models.Expression) bool {
+	if q.Status != "" && expr.Status != q.Status {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && !expr.CreatedAt.After(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !expr.CreatedAt.Before(q.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// position — место выражения в порядке выборки
+type position struct {
+	created int64 // Время создания в наносекундах
+	id      string
+}
+
+func positionOf(expr *models.Expression) position {
+	return position{created: expr.CreatedAt.UnixNano(), id: expr.ID}
+}
+
+func (p position) before(other position) bool {
+	if p.created != other.created {
+		return p.created < other.created
+	}
+	return p.id < other.id
+}
+
+// Курсор — позиция последнего выражения страницы в base64: «наносекунды:ID»
+func (p position) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(p.created, 10) + ":" + p.id))
+}
+
+func decodeCursor(cursor string) (position, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return position{}, errors.ErrInvalidCursor
+	}
+	created, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return position{}, errors.ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return position{}, errors.ErrInvalidCursor
+	}
+	return position{created: nanos, id: id}, nil
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"container/heap"
+	"time"
+)
+
+// DefaultAgingInterval — сколько ожидания в очереди стоит одна ступень приоритета
+const DefaultAgingInterval = 10 * time.Second
+
+// taskQueue — очередь готовых к выполнению задач. Между клиентами (tenant) задачи выдаются
+// по справедливой доле, пропорциональной весу клиента; внутри клиента — по приоритету
+// выражения. Чтобы задачи с низким приоритетом не ждали вечно, каждые agingInterval
+// ожидания поднимают задачу на ступень приоритета
+type taskQueue struct {
+	tenants       map[string]*tenantQueue
+	items         map[string]*queueItem // Задачи в очереди по ID
+	weights       map[string]int        // Веса клиентов; по умолчанию 1
+	agingInterval time.Duration
+	seq           uint64 // Порядок постановки для задач с равным ключом
+}
+
+type tenantQueue struct {
+	name string
+	heap itemHeap
+	pass float64 // Сколько задач выдано с учётом веса: очередь выдаёт задачу клиенту с наименьшим pass
+}
+
+type queueItem struct {
+	taskID string
+	tenant string
+	key    time.Time // Постановка в очередь, сдвинутая назад на priority ступеней
+	seq    uint64
+	index  int
+}
+
+func newTaskQueue() *taskQueue {
+	return &taskQueue{
+		tenants:       make(map[string]*tenantQueue),
+		items:         make(map[string]*queueItem),
+		weights:       make(map[string]int),
+		agingInterval: DefaultAgingInterval,
+	}
+}
+
+// push ставит задачу в очередь. Задача с приоритетом p выдаётся так, будто ждёт
+// на p·agingInterval дольше, поэтому порядок не зависит от момента выбора
+func (q *taskQueue) push(taskID, tenant string, priority int, now time.Time) {
+	if _, queued := q.items[taskID]; queued {
+		return
+	}
+
+	t, ok := q.tenants[tenant]
+	if !ok {
+		t = &tenantQueue{name: tenant}
+		q.tenants[tenant] = t
+	}
+	// Клиент, у которого не было задач, не копит долю за время простоя
+	if len(t.heap) == 0 {
+		if min, ok := q.minPass(); ok && t.pass < min {
+			t.pass = min
+		}
+	}
+
+	q.seq++
+	item := &queueItem{
+		taskID: taskID,
+		tenant: tenant,
+		key:    now.Add(-time.Duration(priority) * q.agingInterval),
+		seq:    q.seq,
+	}
+	heap.Push(&t.heap, item)
+	q.items[taskID] = item
+}
+
+// pop выдаёт задачу клиента с наименьшей использованной долей
+func (q *taskQueue) pop() (string, bool) {
+	var next *tenantQueue
+	for _, t := range q.tenants {
+		if len(t.heap) == 0 {
+			continue
+		}
+		if next == nil || t.pass < next.pass || (t.pass == next.pass && t.name < next.name) {
+			next = t
+		}
+	}
+	if next == nil {
+		return "", false
+	}
+
+	item := heap.Pop(&next.heap).(*queueItem)
+	delete(q.items, item.taskID)
+	next.pass += 1 / float64(q.weight(next.name))
+	return item.taskID, true
+}
+
+// remove убирает задачу из очереди, если она там есть
+func (q *taskQueue) remove(taskID string) {
+	item, ok := q.items[taskID]
+	if !ok {
+		return
+	}
+	heap.Remove(&q.tenants[item.tenant].heap, item.index)
+	delete(q.items, taskID)
+}
+
+func (q *taskQueue) len() int {
+	return len(q.items)
+}
+
+func (q *taskQueue) weight(tenant string) int {
+	if w, ok := q.weights[tenant]; ok {
+		return w
+	}
+	return 1
+}
+
+// minPass — наименьшая доля среди клиентов, у которых есть задачи
+func (q *taskQueue) minPass() (float64, bool) {
+	min, found := 0.0, false
+	for _, t := range q.tenants {
+		if len(t.heap) > 0 && (!found || t.pass < min) {
+			min, found = t.pass, true
+		}
+	}
+	return min, found
+}
+
+// itemHeap упорядочивает задачи клиента по ключу, а при равном ключе — по порядку постановки
+type itemHeap []*queueItem
+
+func (h itemHeap) Len() int { return len(h) }
+
+func (h itemHeap) Less(i, j int) bool {
+	if !h[i].key.Equal(h[j].key) {
+		return h[i].key.Before(h[j].key)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h itemHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *itemHeap) Push(x any) {
+	item := x.(*queueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *itemHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
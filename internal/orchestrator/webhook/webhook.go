@@ -0,0 +1,130 @@
+// Package webhook отправляет результаты завершённых выражений по callback_url,
+// указанному при отправке выражения
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"calc_service/internal/orchestrator/storage"
+	"calc_service/pkg/models"
+)
+
+// SignatureHeader — заголовок с подписью тела: sha256=<HMAC-SHA256 тела с секретом выражения в hex>.
+// Без секрета запрос не подписывается
+const SignatureHeader = "X-Signature-256"
+
+// AttemptHeader — номер попытки доставки, с 1
+const AttemptHeader = "X-Delivery-Attempt"
+
+const (
+	DefaultAttempts = 5           // Сколько раз пытаться доставить результат
+	DefaultBackoff  = time.Second // Пауза перед второй попыткой; каждая следующая вдвое дольше
+	requestTimeout  = 10 * time.Second
+)
+
+// Dispatcher доставляет результаты и записывает каждую попытку в хранилище
+type Dispatcher struct {
+	store    storage.Storage
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	wg       sync.WaitGroup
+}
+
+func NewDispatcher(store storage.Storage) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		client:   &http.Client{Timeout: requestTimeout},
+		attempts: DefaultAttempts,
+		backoff:  DefaultBackoff,
+	}
+}
+
+// SetRetryPolicy задаёт число попыток и паузу перед второй из них
+func (d *Dispatcher) SetRetryPolicy(attempts int, backoff time.Duration) {
+	d.attempts = attempts
+	d.backoff = backoff
+}
+
+// Notify отправляет результат выражения в фоне, если у выражения есть callback_url.
+// Подходит для MemoryStorage.OnExpressionFinished
+func (d *Dispatcher) Notify(expr models.Expression) {
+	if expr.CallbackURL == "" {
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(expr)
+	}()
+}
+
+// Wait дожидается окончания начатых доставок
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(expr models.Expression) {
+	body, err := json.Marshal(expr)
+	if err != nil {
+		return
+	}
+
+	delay := d.backoff
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		delivery, retry := d.send(expr, body, attempt)
+		d.store.AddDelivery(expr.ID, delivery)
+		if !retry || attempt == d.attempts {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send выполняет одну попытку и сообщает, есть ли смысл повторять
+func (d *Dispatcher) send(expr models.Expression, body []byte, attempt int) (models.Delivery, bool) {
+	delivery := models.Delivery{Attempt: attempt, SentAt: time.Now()}
+
+	req, err := http.NewRequest(http.MethodPost, expr.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(AttemptHeader, fmt.Sprint(attempt))
+	if expr.CallbackSecret != "" {
+		req.Header.Set(SignatureHeader, Sign(expr.CallbackSecret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery, true
+	}
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return delivery, false
+	}
+	delivery.Error = resp.Status
+	// Остальные ошибки клиента повтор не исправит
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return delivery, retry
+}
+
+// Sign возвращает значение SignatureHeader для тела запроса
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
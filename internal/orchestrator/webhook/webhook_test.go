@@ -0,0 +1,84 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"calc_service/internal/orchestrator/storage"
+	"calc_service/internal/orchestrator/webhook"
+	"calc_service/pkg/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcher(t *testing.T) {
+	// Получатель отвечает ошибкой на первую попытку и принимает вторую
+	var calls atomic.Int32
+	var received models.Expression
+	var signatureValid bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		signatureValid = r.Header.Get(webhook.SignatureHeader) == webhook.Sign("secret", body)
+		json.Unmarshal(body, &received)
+	}))
+	defer receiver.Close()
+
+	store := storage.NewMemoryStorage()
+	dispatcher := webhook.NewDispatcher(store)
+	dispatcher.SetRetryPolicy(3, time.Millisecond)
+	store.OnExpressionFinished(dispatcher.Notify)
+
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing", CallbackURL: receiver.URL, CallbackSecret: "secret"})
+	store.AddTask(&models.Task{ID: "sum", ExpressionID: "expr", Operation: "+", Arg1: 2, Arg2: 3, Status: "pending"})
+	store.CompleteTask("sum", 5)
+	dispatcher.Wait()
+
+	assert.True(t, signatureValid, "подпись должна совпадать")
+	assert.Equal(t, "done", received.Status)
+	assert.Equal(t, 5.0, received.Result)
+
+	deliveries, err := store.GetDeliveries("expr")
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 2) {
+		assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].StatusCode)
+		assert.NotEmpty(t, deliveries[0].Error)
+		assert.Equal(t, 2, deliveries[1].Attempt)
+		assert.Empty(t, deliveries[1].Error)
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get(webhook.SignatureHeader) != "" {
+			t.Error("Без секрета запрос не подписывается")
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer receiver.Close()
+
+	store := storage.NewMemoryStorage()
+	dispatcher := webhook.NewDispatcher(store)
+	dispatcher.SetRetryPolicy(3, time.Millisecond)
+	store.OnExpressionFinished(dispatcher.Notify)
+
+	// Ошибку клиента повтор не исправит
+	store.AddExpression(&models.Expression{ID: "expr", Status: "processing", CallbackURL: receiver.URL})
+	store.AddTask(&models.Task{ID: "div", ExpressionID: "expr", Operation: "/", Arg1: 1, Arg2: 0, Status: "pending"})
+	store.FailTask("div", "деление на ноль")
+	dispatcher.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	deliveries, _ := store.GetDeliveries("expr")
+	assert.Len(t, deliveries, 1)
+}
@@ -0,0 +1,299 @@
+// Package websocket — серверная сторона протокола WebSocket (RFC 6455) в объёме,
+// нужном API: текстовые и двоичные сообщения, фрагментация, ping/pong и закрытие соединения.
+// Расширения (сжатие) и подпротоколы не поддерживаются
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// acceptGUID — константа из RFC 6455, из которой вычисляется Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize — наибольший размер сообщения после сборки фрагментов
+const MaxMessageSize = 1 << 20
+
+// Коды закрытия соединения
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	CloseTooBig          = 1009
+)
+
+// Коды операций кадров
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// CloseError — собеседник закрыл соединение или нарушил протокол
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: соединение закрыто (%d) %s", e.Code, e.Reason)
+}
+
+// ErrClosed — соединение уже закрыто этой стороной
+var ErrClosed = errors.New("websocket: соединение закрыто")
+
+// Conn — соединение WebSocket на стороне сервера. Чтение выполняется из одной горутины,
+// писать можно из нескольких
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	wmu    sync.Mutex
+	closed bool
+}
+
+// Upgrade переключает HTTP-запрос на протокол WebSocket. Если запрос не является
+// корректным рукопожатием, ответ с ошибкой уже записан в w
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket: рукопожатие должно быть запросом GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Ожидается рукопожатие WebSocket", http.StatusBadRequest)
+		return nil, errors.New("websocket: нет заголовков Connection: Upgrade и Upgrade: websocket")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Поддерживается только версия 13", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: неподдерживаемая версия протокола")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Некорректный Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: некорректный Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Соединение нельзя переключить", http.StatusInternalServerError)
+		return nil, errors.New("websocket: ResponseWriter не поддерживает Hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: rw.Reader}, nil
+}
+
+// AcceptKey вычисляет Sec-WebSocket-Accept для ключа клиента
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains ищет значение в списке через запятую без учёта регистра
+func headerContains(header http.Header, name, value string) bool {
+	for _, line := range header.Values(name) {
+		for _, token := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage возвращает следующее текстовое или двоичное сообщение. На ping отвечает pong,
+// на закрытие — ответным закрытием и *CloseError. При нарушении протокола соединение
+// закрывается с соответствующим кодом
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	var opcode byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			var closeErr *CloseError
+			if errors.As(err, &closeErr) {
+				c.Close(closeErr.Code, closeErr.Reason)
+			}
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code, reason := CloseNoStatus, ""
+			if len(payload) >= 2 {
+				code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+			}
+			echo := code
+			if echo == CloseNoStatus {
+				echo = CloseNormal
+			}
+			c.Close(echo, "")
+			return nil, &CloseError{Code: code, Reason: reason}
+		case opText, opBinary:
+			if opcode != 0 {
+				return nil, c.fail(CloseProtocolError, "новое сообщение до окончания предыдущего")
+			}
+			opcode = op
+		case opContinuation:
+			if opcode == 0 {
+				return nil, c.fail(CloseProtocolError, "продолжение без начала сообщения")
+			}
+		default:
+			return nil, c.fail(CloseProtocolError, "неизвестный код операции")
+		}
+
+		if len(message)+len(payload) > MaxMessageSize {
+			return nil, c.fail(CloseTooBig, "сообщение слишком большое")
+		}
+		message = append(message, payload...)
+		if fin {
+			if opcode == opText && !utf8.Valid(message) {
+				return nil, c.fail(CloseInvalidPayload, "текст не в UTF-8")
+			}
+			return message, nil
+		}
+	}
+}
+
+// readFrame читает один кадр и снимает с него маску клиента
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.reader, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &CloseError{Code: CloseProtocolError, Reason: "расширения не поддерживаются"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &CloseError{Code: CloseProtocolError, Reason: "кадр клиента без маски"}
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, &CloseError{Code: CloseProtocolError, Reason: "некорректный управляющий кадр"}
+	}
+	if length > MaxMessageSize {
+		return false, 0, nil, &CloseError{Code: CloseTooBig, Reason: "сообщение слишком большое"}
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// fail закрывает соединение из-за ошибки собеседника
+func (c *Conn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteMessage отправляет текстовое сообщение одним кадром
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// Кадры сервера не маскируются
+func (c *Conn) writeFrameLocked(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+// Close отправляет кадр закрытия с кодом и причиной и закрывает соединение.
+// Повторный вызов ничего не делает
+func (c *Conn) Close(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	// Причина вместе с кодом должна уместиться в 125 байт управляющего кадра
+	for len(reason) > 123 {
+		_, size := utf8.DecodeLastRuneInString(reason)
+		reason = reason[:len(reason)-size]
+	}
+	c.writeFrameLocked(opClose, append(payload, reason...))
+	return c.conn.Close()
+}
@@ -0,0 +1,163 @@
+package websocket_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"calc_service/internal/orchestrator/websocket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client — клиентская сторона соединения для проверки сервера
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, server *httptest.Server) *client {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, websocket.AcceptKey(key), resp.Header.Get("Sec-WebSocket-Accept"))
+	return &client{conn: conn, reader: reader}
+}
+
+// write отправляет кадр; кадры клиента маскируются, если не сказано иное
+func (c *client) write(fin bool, opcode byte, payload []byte, masked bool) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first, byte(len(payload))}
+	if !masked {
+		c.conn.Write(append(frame, payload...))
+		return
+	}
+	frame[1] |= 0x80
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *client) read(t *testing.T) (byte, []byte) {
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	require.NoError(t, err)
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.reader, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	io.ReadFull(c.reader, payload)
+	return header[0] & 0x0F, payload
+}
+
+// echoServer возвращает каждое сообщение обратно
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(message)
+		}
+	}))
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Пример из RFC 6455, раздел 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", websocket.AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestFragmentsAndPing(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+	c := dial(t, server)
+
+	// Ping между фрагментами сообщения получает pong сразу
+	c.write(false, 0x1, []byte("при"), true)
+	c.write(true, 0x9, []byte("ping"), true)
+	c.write(true, 0x0, []byte("вет"), true)
+
+	opcode, payload := c.read(t)
+	assert.Equal(t, byte(0xA), opcode)
+	assert.Equal(t, "ping", string(payload))
+
+	opcode, payload = c.read(t)
+	assert.Equal(t, byte(0x1), opcode)
+	assert.Equal(t, "привет", string(payload))
+
+	// Закрытие получает ответное закрытие с тем же кодом
+	c.write(true, 0x8, binary.BigEndian.AppendUint16(nil, websocket.CloseNormal), true)
+	opcode, payload = c.read(t)
+	assert.Equal(t, byte(0x8), opcode)
+	assert.Equal(t, uint16(websocket.CloseNormal), binary.BigEndian.Uint16(payload))
+}
+
+func TestProtocolErrors(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	for name, tc := range map[string]struct {
+		send func(*client)
+		code uint16
+	}{
+		"Кадр без маски":         {func(c *client) { c.write(true, 0x1, []byte("x"), false) }, websocket.CloseProtocolError},
+		"Продолжение без начала": {func(c *client) { c.write(true, 0x0, []byte("x"), true) }, websocket.CloseProtocolError},
+		"Текст не в UTF-8":       {func(c *client) { c.write(true, 0x1, []byte{0xff, 0xfe}, true) }, websocket.CloseInvalidPayload},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := dial(t, server)
+			tc.send(c)
+			opcode, payload := c.read(t)
+			assert.Equal(t, byte(0x8), opcode)
+			assert.Equal(t, tc.code, binary.BigEndian.Uint16(payload))
+		})
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	server := echoServer()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+}
@@ -16,4 +16,26 @@ var (
 	ErrInvalidJSON         = fmt.Errorf("некорректный JSON")
 	ErrEmptyExpression     = fmt.Errorf("пустое выражение")
 	ErrInternalServerError = fmt.Errorf("внутренняя ошибка сервера")
+	ErrFunctionNotFound    = fmt.Errorf("функция не найдена")
+	ErrFunctionExists      = fmt.Errorf("функция уже существует")
+	ErrInvalidFunction     = fmt.Errorf("некорректное определение функции")
+	ErrRecursiveFunction   = fmt.Errorf("рекурсивный вызов функции")
+	ErrArgumentCount       = fmt.Errorf("неверное количество аргументов")
+	ErrUnknownVariable     = fmt.Errorf("неизвестная переменная")
+	ErrNegativeRoot        = fmt.Errorf("корень из отрицательного числа")
+	ErrListLength          = fmt.Errorf("списки разной длины")
+	ErrEmptyList           = fmt.Errorf("пустой список")
+	ErrMatrixShape         = fmt.Errorf("неподходящая размерность матрицы")
+	ErrSingularMatrix      = fmt.Errorf("вырожденная матрица")
+	ErrInvalidOption       = fmt.Errorf("некорректный параметр запроса")
+	ErrUnknownUnit         = fmt.Errorf("неизвестная единица измерения")
+	ErrDimensionMismatch   = fmt.Errorf("несовместимые размерности")
+	ErrUnknownCurrency     = fmt.Errorf("неизвестная валюта")
+	ErrExpressionCancelled = fmt.Errorf("выражение отменено")
+	ErrExpressionFinished  = fmt.Errorf("выражение уже вычислено")
+	ErrExpressionFailed    = fmt.Errorf("выражение завершилось с ошибкой")
+	ErrInvalidCursor       = fmt.Errorf("некорректный курсор")
+	ErrBatchNotFound       = fmt.Errorf("пакет не найден")
+	ErrBatchExists         = fmt.Errorf("пакет уже существует")
+	ErrIdempotencyConflict = fmt.Errorf("ключ идемпотентности уже использован с другим запросом")
 )
@@ -1,26 +1,293 @@
 package models
 
 import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Виды результата, которые выдаются записью ISO 8601
+const (
+	ResultDate     = "date"     // Секунды от начала эпохи Unix: 2026-10-21 или 2026-10-21T04:00:00Z
+	ResultDuration = "duration" // Длительность в секундах: P3DT4H
+)
+
 // Expression представляет арифметическое выражение для вычисления
 type Expression struct {
-	ID        string    `json:"id"`         // Уникальный идентификатор
-	Status    string    `json:"status"`     // Статус: pending/processing/done/error
-	Result    float64   `json:"result"`     // Результат вычисления
-	CreatedAt time.Time `json:"created_at"` // Время создания
-	UpdatedAt time.Time `json:"updated_at"` // Время последнего обновления
+	ID             string     `json:"id"`                     // Уникальный идентификатор
+	Expression     string     `json:"expression"`             // Исходная запись выражения
+	Syntax         string     `json:"syntax,omitempty"`       // Нотация записи, если это не обычная запись
+	Mode           string     `json:"mode,omitempty"`         // interval — Results содержит границы [нижняя, верхняя]
+	Status         string     `json:"status"`                 // Статус: pending/processing/done/error/cancelled
+	Result         float64    `json:"result"`                 // Результат вычисления
+	Unit           string     `json:"unit,omitempty"`         // Единица результата, если в выражении есть величины с единицами
+	ResultType     string     `json:"result_type,omitempty"`  // ResultDate или ResultDuration; пусто — число
+	Value          string     `json:"value,omitempty"`        // Результат-дата или длительность в ISO 8601
+	Values         []string   `json:"values,omitempty"`       // Results в ISO 8601
+	Results        []float64  `json:"results,omitempty"`      // Поэлементный результат, если выражение — список или матрица
+	Shape          []int      `json:"shape,omitempty"`        // Размерность Results: [n] для списка, [строки, столбцы] для матрицы
+	ResultTasks    []string   `json:"-"`                      // Задачи, вычисляющие элементы Results
+	ResultIndex    []int      `json:"-"`                      // Индексы элементов в результатах-массивах ResultTasks
+	Error          string     `json:"error,omitempty"`        // Описание ошибки для статуса error
+	CallbackURL    string     `json:"callback_url,omitempty"` // Куда отправить результат после завершения
+	CallbackSecret string     `json:"-"`                      // Секрет для подписи результата, отправляемого по CallbackURL
+	Priority       int        `json:"priority,omitempty"`     // Приоритет задач выражения в очереди: от 0 до 9, больше — раньше
+	Tenant         string     `json:"tenant,omitempty"`       // Клиент; агенты делятся между клиентами поровну или по весам
+	Deadline       *time.Time `json:"deadline,omitempty"`     // Не вычисленное к этому времени выражение завершается с ошибкой ErrTimeout
+	CreatedAt      time.Time  `json:"created_at"`             // Время создания
+	UpdatedAt      time.Time  `json:"updated_at"`             // Время последнего обновления
+}
+
+// Clone возвращает копию выражения, которая не делит с ним срезы
+func (e *Expression) Clone() *Expression {
+	c := *e
+	c.Values = slices.Clone(e.Values)
+	c.Results = slices.Clone(e.Results)
+	c.Shape = slices.Clone(e.Shape)
+	c.ResultTasks = slices.Clone(e.ResultTasks)
+	c.ResultIndex = slices.Clone(e.ResultIndex)
+	return &c
+}
+
+// FormatResult записывает готовый результат-дату или длительность в ISO 8601
+func (e *Expression) FormatResult() {
+	format := FormatDate
+	switch e.ResultType {
+	case ResultDate:
+	case ResultDuration:
+		format = FormatDuration
+	default:
+		return
+	}
+
+	if e.Results == nil {
+		e.Value = format(e.Result)
+		return
+	}
+	e.Values = make([]string, len(e.Results))
+	for i, v := range e.Results {
+		e.Values[i] = format(v)
+	}
+}
+
+// FormatDate записывает момент времени в секундах от начала эпохи: полночь — только датой
+func FormatDate(seconds float64) string {
+	whole := math.Floor(seconds)
+	t := time.Unix(int64(whole), int64((seconds-whole)*1e9)).UTC()
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339Nano)
 }
 
-// Task представляет отдельную вычислительную операцию
+// FormatDuration записывает длительность в секундах как P3DT4H; сутки считаются равными 24 часам
+func FormatDuration(seconds float64) string {
+	var b strings.Builder
+	if seconds < 0 {
+		b.WriteByte('-')
+		seconds = -seconds
+	}
+	b.WriteByte('P')
+
+	days := math.Floor(seconds / 86400)
+	seconds -= days * 86400
+	if days > 0 {
+		fmt.Fprintf(&b, "%.0fD", days)
+	}
+	if seconds == 0 {
+		if days == 0 {
+			b.WriteString("T0S")
+		}
+		return b.String()
+	}
+
+	b.WriteByte('T')
+	hours := math.Floor(seconds / 3600)
+	seconds -= hours * 3600
+	minutes := math.Floor(seconds / 60)
+	seconds -= minutes * 60
+	if hours > 0 {
+		fmt.Fprintf(&b, "%.0fH", hours)
+	}
+	if minutes > 0 {
+		fmt.Fprintf(&b, "%.0fM", minutes)
+	}
+	if seconds > 0 {
+		b.WriteString(strconv.FormatFloat(seconds, 'f', -1, 64) + "S")
+	}
+	return b.String()
+}
+
+// ResultIndexAt возвращает индекс элемента результата-массива для i-го элемента Results
+func (e *Expression) ResultIndexAt(i int) int {
+	return indexAt(e.ResultIndex, i)
+}
+
+// Task представляет отдельную вычислительную операцию.
+// Операнд, который вычисляет другая задача, задаётся её ID в Arg1Task/Arg2Task/ArgTasks;
+// если та задача возвращает массив, в операнд попадает элемент с индексом из Arg1Index/Arg2Index/ArgIndex.
 type Task struct {
-	ID            string  `json:"id"`             // Уникальный идентификатор
-	ExpressionID  string  `json:"expression_id"`  // Связь с выражением
-	Arg1          float64 `json:"arg1"`           // Первый операнд
-	Arg2          float64 `json:"arg2"`           // Второй операнд
-	Operation     string  `json:"operation"`      // Операция: +, -, *, /
-	OperationTime int     `json:"operation_time"` // Время выполнения в мс
-	Status        string  `json:"status"`         // Статус: pending/processing/done
-	Result        float64 `json:"result"`         // Результат вычисления
+	ID            string     `json:"id"`                    // Уникальный идентификатор
+	ExpressionID  string     `json:"expression_id"`         // Связь с выражением
+	Arg1          float64    `json:"arg1"`                  // Первый операнд
+	Arg2          float64    `json:"arg2"`                  // Второй операнд
+	Arg1Task      string     `json:"arg1_task,omitempty"`   // Задача, результат которой станет первым операндом
+	Arg2Task      string     `json:"arg2_task,omitempty"`   // Задача, результат которой станет вторым операндом
+	Arg1Index     int        `json:"arg1_index,omitempty"`  // Элемент результата-массива Arg1Task
+	Arg2Index     int        `json:"arg2_index,omitempty"`  // Элемент результата-массива Arg2Task
+	Args          []float64  `json:"args,omitempty"`        // Операнды-массивы по строкам (median, matmul, det, inv)
+	ArgTasks      []string   `json:"arg_tasks,omitempty"`   // Задачи для элементов Args; пустая строка — значение известно
+	ArgIndex      []int      `json:"arg_index,omitempty"`   // Элементы результатов-массивов ArgTasks
+	Shape         []int      `json:"shape,omitempty"`       // Размеры операндов в Args: [m, n, p] для matmul, [n, n] для det и inv
+	Operation     string     `json:"operation"`             // Операция: +, -, *, /, sqrt, abs, median, matmul, det, inv
+	OperationTime int        `json:"operation_time"`        // Время выполнения в мс
+	Status        string     `json:"status"`                // Статус: pending/processing/done/error/cancelled
+	AgentID       string     `json:"agent_id,omitempty"`    // Агент, которому выдана задача
+	Result        float64    `json:"result"`                // Результат вычисления
+	Results       []float64  `json:"results,omitempty"`     // Результат-массив по строкам (matmul, inv)
+	Error         string     `json:"error,omitempty"`       // Ошибка, о которой сообщил агент
+	StartedAt     *time.Time `json:"started_at,omitempty"`  // Когда задача последний раз выдана агенту
+	FinishedAt    *time.Time `json:"finished_at,omitempty"` // Когда получен результат
+	Retries       int        `json:"retries,omitempty"`     // Сколько раз задачу выдавали повторно
+	// Interval — операция над интервалами: Args — границы операндов подряд,
+	// Results — границы результата [нижняя, верхняя]
+	Interval bool `json:"interval,omitempty"`
+	// Decimal — операнды понимаются как десятичные дроби в кратчайшей записи, и + - * /
+	// выполняются над ними точно, а не в двоичной плавающей точке (денежные суммы)
+	Decimal bool `json:"decimal,omitempty"`
+}
+
+// TaskResult — результат выполнения задачи, который агент отправляет оркестратору
+type TaskResult struct {
+	TaskID  string    `json:"task_id"`
+	Result  float64   `json:"result"`
+	Results []float64 `json:"results,omitempty"` // Для операций, возвращающих массив
+	Error   string    `json:"error,omitempty"`   // Агент не смог выполнить задачу
+}
+
+// IsListOperation сообщает, принимает ли операция операнды-массивы в Args
+func IsListOperation(op string) bool {
+	switch op {
+	case "median", "matmul", "det", "inv":
+		return true
+	}
+	return false
+}
+
+// IsArrayOperation сообщает, возвращает ли операция массив в Results
+func IsArrayOperation(op string) bool {
+	return op == "matmul" || op == "inv"
+}
+
+// Clone возвращает копию задачи, которая не делит с ней срезы
+func (t *Task) Clone() *Task {
+	c := *t
+	c.Args = slices.Clone(t.Args)
+	c.ArgTasks = slices.Clone(t.ArgTasks)
+	c.ArgIndex = slices.Clone(t.ArgIndex)
+	c.Shape = slices.Clone(t.Shape)
+	c.Results = slices.Clone(t.Results)
+	return &c
+}
+
+// ReturnsArray сообщает, что результат задачи — массив в Results
+func (t *Task) ReturnsArray() bool {
+	return t.Interval || IsArrayOperation(t.Operation)
+}
+
+// Dependencies возвращает ID задач, результаты которых нужны для выполнения этой
+func (t *Task) Dependencies() []string {
+	var deps []string
+	for _, dep := range append([]string{t.Arg1Task, t.Arg2Task}, t.ArgTasks...) {
+		if dep != "" {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// UniqueDependencies возвращает Dependencies без повторов: x*x зависит от x один раз
+func (t *Task) UniqueDependencies() []string {
+	deps := []string{}
+	seen := make(map[string]bool)
+	for _, dep := range t.Dependencies() {
+		if !seen[dep] {
+			seen[dep] = true
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// Value возвращает результат задачи или элемент её результата-массива
+func (t *Task) Value(index int) float64 {
+	if t.Results != nil {
+		return t.Results[index]
+	}
+	return t.Result
+}
+
+// FillOperand подставляет результат выполненной задачи во все операнды, которые его ждут
+func (t *Task) FillOperand(done *Task) {
+	if t.Arg1Task == done.ID {
+		t.Arg1 = done.Value(t.Arg1Index)
+	}
+	if t.Arg2Task == done.ID {
+		t.Arg2 = done.Value(t.Arg2Index)
+	}
+	for i, dep := range t.ArgTasks {
+		if dep == done.ID {
+			t.Args[i] = done.Value(indexAt(t.ArgIndex, i))
+		}
+	}
+}
+
+// indexAt возвращает индекс элемента i; отсутствующий индекс означает скалярный результат
+func indexAt(indexes []int, i int) int {
+	if i < len(indexes) {
+		return indexes[i]
+	}
+	return 0
+}
+
+// Delivery — попытка отправить результат выражения по CallbackURL
+type Delivery struct {
+	Attempt    int       `json:"attempt"`               // Номер попытки, с 1
+	SentAt     time.Time `json:"sent_at"`               // Когда отправлен запрос
+	StatusCode int       `json:"status_code,omitempty"` // Код ответа получателя
+	Error      string    `json:"error,omitempty"`       // Почему попытка не удалась
+}
+
+// Batch — выражения, отправленные одним запросом; элементы идут в порядке запроса
+type Batch struct {
+	ID        string      `json:"id"`         // Уникальный идентификатор
+	Items     []BatchItem `json:"items"`      // Элементы пакета
+	CreatedAt time.Time   `json:"created_at"` // Время создания
+}
+
+// BatchItem — элемент пакета: принятое выражение или причина, по которой оно отклонено
+type BatchItem struct {
+	Key          string `json:"key,omitempty"`   // Ключ, заданный клиентом
+	ExpressionID string `json:"id,omitempty"`    // ID принятого выражения
+	Error        string `json:"error,omitempty"` // Почему выражение не принято
+}
+
+// IdempotencyKey связывает ключ из заголовка Idempotency-Key с запросом и созданным по нему выражением
+type IdempotencyKey struct {
+	Key          string    // Ключ, заданный клиентом
+	Tenant       string    // Клиент: одинаковые ключи разных клиентов не пересекаются
+	Fingerprint  string    // Отпечаток тела запроса: повтор с другим телом — конфликт
+	ExpressionID string    // Выражение, созданное по первому запросу
+	CreatedAt    time.Time // Время первого запроса
+}
+
+// Function представляет пользовательскую функцию, зарегистрированную через API
+type Function struct {
+	Name      string    `json:"name"`       // Имя, по которому функция вызывается в выражениях
+	Params    []string  `json:"params"`     // Имена параметров
+	Body      string    `json:"body"`       // Тело функции — выражение от параметров
+	CreatedAt time.Time `json:"created_at"` // Время регистрации
 }
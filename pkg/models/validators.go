@@ -3,6 +3,7 @@ package models
 import (
 	"calc_service/pkg/errors"
 	"strings"
+	"unicode"
 )
 
 // ValidateExpression проверяет корректность структуры выражения
@@ -16,6 +17,7 @@ func (e *Expression) Validate() error {
 		"processing": true,
 		"done":       true,
 		"error":      true,
+		"cancelled":  true,
 	}
 
 	if !allowedStatuses[e.Status] {
@@ -32,23 +34,63 @@ func (t *Task) Validate() error {
 	}
 
 	allowedOperations := map[string]bool{
-		"+": true,
-		"-": true,
-		"*": true,
-		"/": true,
+		"+":       true,
+		"-":       true,
+		"*":       true,
+		"/":       true,
+		"sqrt":    true,
+		"abs":     true,
+		"weekday": true,
+		"median":  true,
+		"matmul":  true,
+		"det":     true,
+		"inv":     true,
 	}
 
 	if !allowedOperations[t.Operation] {
 		return errors.ErrInvalidOperation
 	}
 
-	if t.Operation == "/" && t.Arg2 == 0 {
+	if t.Operation == "/" && t.Arg2Task == "" && t.Arg2 == 0 {
 		return errors.ErrDivisionByZero
 	}
 
 	return nil
 }
 
+// Validate проверяет имя, параметры и тело пользовательской функции
+func (f *Function) Validate() error {
+	if !IsIdentifier(f.Name) || strings.TrimSpace(f.Body) == "" {
+		return errors.ErrInvalidFunction
+	}
+
+	seen := make(map[string]bool, len(f.Params))
+	for _, p := range f.Params {
+		if !IsIdentifier(p) || seen[p] {
+			return errors.ErrInvalidFunction
+		}
+		seen[p] = true
+	}
+
+	return nil
+}
+
+// IsIdentifier проверяет, что строка — допустимое имя функции или параметра
+func IsIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r > unicode.MaxASCII {
+			return false
+		}
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
 // SanitizeExpression очищает ввод выражения
 func SanitizeExpression(expr string) string {
 	return strings.TrimSpace(expr)